@@ -0,0 +1,299 @@
+package rtree
+
+import (
+	"math"
+	"slices"
+
+	"github.com/paulmach/orb"
+)
+
+// Geometries that cross the antimeridian are expected to do so "the short way" meaning that consecutive points
+// on either side of the antimeridian (for example 179 and -179) are assumed to be connected by a segment that
+// crosses it rather than one which spans (almost) the entire globe. A naive bounding box for such geometries
+// spans the entire globe so instead they are indexed using two bounding boxes, one on either side of the
+// antimeridian, and tested by shifting their coordinates so that they are continuous.
+//
+// Query bounding boxes that cross the antimeridian are represented by a `orb.Bound` whose minimum longitude is
+// greater than its maximum longitude (for example 170 to -170) and are split in to two boxes by `splitBound`.
+
+// crossesAntimeridian returns a boolean value indicating whether any of the segments between consecutive
+// points in 'points' crosses the antimeridian. Segments between two points on the antimeridian, or on the same
+// pole, run along it rather than crossing it. This is the case for the rings of features, like Antarctica, which
+// span every longitude. Points are only considered to cross the antimeridian if doing so makes them narrower.
+func crossesAntimeridian(points []orb.Point) bool {
+
+	crosses := false
+
+	for i := 1; i < len(points); i++ {
+
+		a := points[i-1]
+		b := points[i]
+
+		if onAntimeridian(a) && onAntimeridian(b) {
+			continue
+		}
+
+		if a.Y() == b.Y() && math.Abs(a.Y()) == 90.0 {
+			continue
+		}
+
+		if math.Abs(b.X()-a.X()) > 180.0 {
+			crosses = true
+			break
+		}
+	}
+
+	if !crosses {
+		return false
+	}
+
+	b := orb.MultiPoint(points).Bound()
+
+	unwrapped := shiftLongitudes(orb.MultiPoint(slices.Clone(points)), unwrapEast).Bound()
+
+	return unwrapped.Max.X()-unwrapped.Min.X() < b.Max.X()-b.Min.X()
+}
+
+// onAntimeridian returns a boolean value indicating whether 'pt' is on the antimeridian (180 or -180 degrees).
+func onAntimeridian(pt orb.Point) bool {
+	return math.Abs(pt.X()) == 180.0
+}
+
+// geometryCrossesAntimeridian returns a boolean value indicating whether any of the rings or lines in
+// 'orb_geom' cross the antimeridian.
+func geometryCrossesAntimeridian(orb_geom orb.Geometry) bool {
+
+	switch g := orb_geom.(type) {
+	case orb.LineString:
+		return crossesAntimeridian(g)
+	case orb.Ring:
+		return crossesAntimeridian(g)
+	case orb.Polygon:
+
+		// Interior rings (holes) are contained by the exterior ring so only it needs to be checked
+
+		return len(g) > 0 && crossesAntimeridian(g[0])
+
+	case orb.MultiLineString:
+
+		for _, line := range g {
+
+			if crossesAntimeridian(line) {
+				return true
+			}
+		}
+
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+
+			if geometryCrossesAntimeridian(poly) {
+				return true
+			}
+		}
+
+	case orb.Collection:
+
+		for _, other := range g {
+
+			if geometryCrossesAntimeridian(other) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// antimeridianBounds returns the bounding boxes for 'points' on the eastern and western sides of the antimeridian.
+// It is assumed that 'points' crosses the antimeridian.
+func antimeridianBounds(points []orb.Point) []orb.Bound {
+
+	min_y := math.MaxFloat64
+	max_y := -math.MaxFloat64
+
+	// The smallest non-negative longitude and the largest negative longitude
+
+	east_x := 180.0
+	west_x := -180.0
+
+	for _, pt := range points {
+
+		min_y = math.Min(min_y, pt.Y())
+		max_y = math.Max(max_y, pt.Y())
+
+		if pt.X() >= 0.0 {
+			east_x = math.Min(east_x, pt.X())
+		} else {
+			west_x = math.Max(west_x, pt.X())
+		}
+	}
+
+	bounds := []orb.Bound{
+		{Min: orb.Point{east_x, min_y}, Max: orb.Point{180.0, max_y}},
+		{Min: orb.Point{-180.0, min_y}, Max: orb.Point{west_x, max_y}},
+	}
+
+	return bounds
+}
+
+// antimeridianTestFunc returns a `geometryTestFunc` which applies 'test_func' to geometries that do not cross
+// the antimeridian and, for geometries that do, applies 'test_func' to copies of the geometry whose crossing
+// parts are shifted so that they are continuous on the eastern (longitudes greater than 180) and western
+// (longitudes less than -180) sides of the antimeridian.
+func antimeridianTestFunc(test_func geometryTestFunc) geometryTestFunc {
+
+	return func(orb_geom orb.Geometry) bool {
+
+		if !geometryCrossesAntimeridian(orb_geom) {
+			return test_func(orb_geom)
+		}
+
+		east, west := unwrapGeometry(orb_geom)
+		return test_func(east) || test_func(west)
+	}
+}
+
+// unwrapGeometry returns copies of 'orb_geom' in which the lines and polygons that cross the antimeridian have
+// their negative longitudes shifted east by 360 degrees and their positive longitudes shifted west by 360 degrees.
+// Parts which do not cross the antimeridian (for example the other islands in a multi-polygon) are left as-is.
+func unwrapGeometry(orb_geom orb.Geometry) (orb.Geometry, orb.Geometry) {
+
+	east := unwrapCrossingParts(orb.Clone(orb_geom), unwrapEast)
+	west := unwrapCrossingParts(orb.Clone(orb_geom), unwrapWest)
+
+	return east, west
+}
+
+// unwrapEast shifts the negative longitude 'x' east by 360 degrees.
+func unwrapEast(x float64) float64 {
+
+	if x < 0.0 {
+		return x + 360.0
+	}
+
+	return x
+}
+
+// unwrapWest shifts the positive longitude 'x' west by 360 degrees.
+func unwrapWest(x float64) float64 {
+
+	if x > 0.0 {
+		return x - 360.0
+	}
+
+	return x
+}
+
+// unwrapCrossingParts updates, in place, the longitude of every point in the lines and polygons in 'orb_geom'
+// which cross the antimeridian using 'shift_func'.
+func unwrapCrossingParts(orb_geom orb.Geometry, shift_func func(float64) float64) orb.Geometry {
+
+	switch g := orb_geom.(type) {
+	case orb.LineString, orb.Ring, orb.Polygon:
+
+		if geometryCrossesAntimeridian(g) {
+			return shiftLongitudes(g, shift_func)
+		}
+
+	case orb.MultiLineString:
+
+		for i, line := range g {
+			g[i] = unwrapCrossingParts(line, shift_func).(orb.LineString)
+		}
+
+	case orb.MultiPolygon:
+
+		for i, poly := range g {
+			g[i] = unwrapCrossingParts(poly, shift_func).(orb.Polygon)
+		}
+
+	case orb.Collection:
+
+		for i, other := range g {
+			g[i] = unwrapCrossingParts(other, shift_func)
+		}
+	}
+
+	return orb_geom
+}
+
+// shiftLongitudes updates, in place, the longitude of every point in 'orb_geom' using 'shift_func'.
+func shiftLongitudes(orb_geom orb.Geometry, shift_func func(float64) float64) orb.Geometry {
+
+	shift := func(points []orb.Point) {
+
+		for i, pt := range points {
+			points[i] = orb.Point{shift_func(pt.X()), pt.Y()}
+		}
+	}
+
+	switch g := orb_geom.(type) {
+	case orb.Point:
+		return orb.Point{shift_func(g.X()), g.Y()}
+	case orb.MultiPoint:
+		shift(g)
+	case orb.LineString:
+		shift(g)
+	case orb.Ring:
+		shift(g)
+	case orb.Polygon:
+
+		for _, ring := range g {
+			shift(ring)
+		}
+
+	case orb.MultiLineString:
+
+		for _, line := range g {
+			shift(line)
+		}
+
+	case orb.MultiPolygon:
+
+		for _, poly := range g {
+
+			for _, ring := range poly {
+				shift(ring)
+			}
+		}
+
+	case orb.Collection:
+
+		for i, other := range g {
+			g[i] = shiftLongitudes(other, shift_func)
+		}
+	}
+
+	return orb_geom
+}
+
+// splitBound returns 'b' as a list containing a single bounding box or, if 'b' crosses the antimeridian
+// (its minimum longitude is greater than its maximum longitude), two bounding boxes on either side of it.
+func splitBound(b orb.Bound) []orb.Bound {
+
+	if b.Min.X() <= b.Max.X() {
+		return []orb.Bound{b}
+	}
+
+	bounds := []orb.Bound{
+		{Min: b.Min, Max: orb.Point{180.0, b.Max.Y()}},
+		{Min: orb.Point{-180.0, b.Min.Y()}, Max: b.Max},
+	}
+
+	return bounds
+}
+
+// wrapLongitude returns the longitude 'x' normalized to the -180 to 180 range.
+func wrapLongitude(x float64) float64 {
+
+	for x > 180.0 {
+		x -= 360.0
+	}
+
+	for x < -180.0 {
+		x += 360.0
+	}
+
+	return x
+}
@@ -0,0 +1,209 @@
+package rtree
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+func TestSpatialDatabaseAntimeridian(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	err = IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/dateline")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	for _, id := range []string{"9000001", "9000002"} {
+
+		entries := rtree_db.current.Load().lookup[id]
+
+		if len(entries) != 2 {
+			t.Fatalf("Expected %s to have 2 rtree entries but got %d", id, len(entries))
+		}
+
+		for _, sp := range entries {
+
+			b := boundFromRect(*sp.Rect)
+
+			if b.Max.X()-b.Min.X() > 2.0 {
+				t.Fatalf("Expected entry for %s not to span the globe, %v", id, b)
+			}
+		}
+	}
+
+	// 9000006 spans every longitude and its ring runs along the antimeridian and the south pole, rather than
+	// crossing the antimeridian, so it should be indexed using a single bounding box
+
+	if len(rtree_db.current.Load().lookup["9000006"]) != 1 {
+		t.Fatalf("Expected 9000006 to have 1 rtree entry")
+	}
+
+	pip_tests := map[orb.Point][]string{
+		{179, -17}:    {"9000001"},
+		{-179, -17}:   {"9000001"},
+		{0.5, 0.5}:    {"9000003"},
+		{170, -17}:    {},
+		{10, -80}:     {"9000006"},
+		{-100, -75}:   {"9000006"},
+		{179, -70}:    {"9000006"},
+		{-179.5, -61}: {"9000006"},
+		{10, -50}:     {},
+	}
+
+	for c, expected := range pip_tests {
+
+		rsp, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertIds(t, rsp.Results(), expected)
+	}
+
+	// The dateline-crossing features should not be candidates for queries on the other side of the world
+
+	c := orb.Point{0.5, 0.5}
+
+	candidates, err := db.PointInPolygonCandidates(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon candidates query, %v", err)
+	}
+
+	if len(candidates) != 1 || candidates[0].FeatureId != "9000003" {
+		t.Fatalf("Unexpected candidates %v", candidates)
+	}
+
+	// A bounding box which crosses the antimeridian
+
+	b := orb.Bound{Min: orb.Point{179.5, -1}, Max: orb.Point{-179.5, 11}}
+
+	rsp, err := rtree_db.Intersects(ctx, b)
+
+	if err != nil {
+		t.Fatalf("Failed to perform intersects query, %v", err)
+	}
+
+	assertIds(t, rsp.Results(), []string{"9000002", "9000004", "9000005"})
+
+	// 9000005 is about 5.5km away and 9000004 is about 16.7km away, on the other side of the antimeridian
+
+	coord := orb.Point{179.95, 0}
+
+	within, err := rtree_db.WithinDistance(ctx, &coord, 20000)
+
+	if err != nil {
+		t.Fatalf("Failed to perform within distance query, %v", err)
+	}
+
+	if len(within) != 2 || within[0].Place.Id() != "9000005" || within[1].Place.Id() != "9000004" {
+		t.Fatalf("Unexpected within distance results %v", within)
+	}
+
+	if within[1].Distance > 17000 {
+		t.Fatalf("Expected 9000004 to be measured across the antimeridian but got %f meters", within[1].Distance)
+	}
+
+	nearest, err := rtree_db.Nearest(ctx, &coord, 2)
+
+	if err != nil {
+		t.Fatalf("Failed to perform nearest query, %v", err)
+	}
+
+	if len(nearest) != 2 || nearest[0].Place.Id() != "9000005" || nearest[1].Place.Id() != "9000004" {
+		t.Fatalf("Unexpected nearest results %v", nearest)
+	}
+
+	for _, c := range []orb.Point{{10, -80}, {-100, -75}, {179, -70}} {
+
+		within, err := rtree_db.WithinDistance(ctx, &c, 1000)
+
+		if err != nil {
+			t.Fatalf("Failed to perform within distance query, %v", err)
+		}
+
+		if len(within) != 1 || within[0].Place.Id() != "9000006" || within[0].Distance != 0.0 {
+			t.Fatalf("Expected %v to be within 9000006, got %v", c, within)
+		}
+
+		nearest, err := rtree_db.Nearest(ctx, &c, 1)
+
+		if err != nil {
+			t.Fatalf("Failed to perform nearest query, %v", err)
+		}
+
+		if len(nearest) != 1 || nearest[0].Place.Id() != "9000006" || nearest[0].Distance != 0.0 {
+			t.Fatalf("Expected nearest feature to %v to be 9000006 at distance 0, got %v", c, nearest)
+		}
+	}
+}
+
+func TestBoundWithRadiusAntimeridian(t *testing.T) {
+
+	b := boundWithRadius(orb.Point{179.95, 0}, 20000)
+
+	if b.Min.X() <= b.Max.X() {
+		t.Fatalf("Expected bound to cross the antimeridian, %v", b)
+	}
+
+	bounds := splitBound(b)
+
+	if len(bounds) != 2 || bounds[0].Max.X() != 180.0 || bounds[1].Min.X() != -180.0 {
+		t.Fatalf("Unexpected split bounds %v", bounds)
+	}
+}
+
+func TestCrossesAntimeridian(t *testing.T) {
+
+	tests := []struct {
+		points  []orb.Point
+		crosses bool
+	}{
+		{[]orb.Point{{178, -18}, {-178, -18}, {-178, -16}, {178, -16}, {178, -18}}, true},
+		{[]orb.Point{{170, 0}, {175, 0}}, false},
+		// Along the antimeridian and the south pole
+		{[]orb.Point{{-180, -60}, {0, -60}, {180, -60}, {180, -90}, {-180, -90}, {-180, -60}}, false},
+		// Crossing the antimeridian would make these points wider, not narrower
+		{[]orb.Point{{100, 0}, {-100, 0}, {0, 0}}, false},
+	}
+
+	for _, test := range tests {
+
+		if crossesAntimeridian(test.points) != test.crosses {
+			t.Fatalf("Expected crossesAntimeridian to be %t for %v", test.crosses, test.points)
+		}
+	}
+}
+
+// assertIds fails 't' if the IDs of 'results' are not the same as 'expected' (in any order).
+func assertIds(t *testing.T, results []spr.StandardPlacesResult, expected []string) {
+
+	ids := make([]string, 0)
+
+	for _, s := range results {
+		ids = append(ids, s.Id())
+	}
+
+	slices.Sort(ids)
+
+	if !slices.Equal(ids, expected) {
+		t.Fatalf("Expected %v but got %v", expected, ids)
+	}
+}
@@ -0,0 +1,198 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+)
+
+// IndexFeatures indexes 'bodies' in a single pass. Features are parsed in parallel and then the rtree is
+// rebuilt, using rtreego's bulk-loading (Sort-Tile-Recursive) algorithm, from the new features and any
+// features already in the database. This produces a better packed tree than calling `IndexFeature` for each
+// feature and is intended for building an index from scratch. If a feature
+// appears more than once in 'bodies' the last instance wins. If any feature fails to parse then an error is
+// returned and the database is left unchanged.
+func (r *RTreeSpatialDatabase) IndexFeatures(ctx context.Context, bodies ...[]byte) error {
+
+	records := make([]*indexRecord, len(bodies))
+	errs := make([]error, len(bodies))
+
+	offsets := make(chan int)
+	wg := new(sync.WaitGroup)
+
+	workers := runtime.NumCPU()
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for idx := range offsets {
+				records[idx], errs[idx] = r.newIndexRecord(ctx, bodies[idx])
+			}
+		}()
+	}
+
+feed:
+	for idx := range bodies {
+
+		select {
+		case <-ctx.Done():
+			break feed
+		case offsets <- idx:
+			// pass
+		}
+	}
+
+	close(offsets)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	for idx, err := range errs {
+
+		if err != nil {
+			return fmt.Errorf("Failed to index feature at offset %d, %w", idx, err)
+		}
+	}
+
+	return r.loadIndexRecords(ctx, records)
+}
+
+// loadIndexRecords replaces any existing entries, and cache items, for the features in 'records' and then
+// rebuilds the rtree of the current generation using rtreego's bulk-loading algorithm. The cache items are stored,
+// and the new rtree is built, without holding the write lock so queries are only blocked while the new rtree is
+// swapped in. Queries made in the meantime may see the new cache item for a feature which is already indexed
+// before its new entries are swapped in.
+func (r *RTreeSpatialDatabase) loadIndexRecords(ctx context.Context, records []*indexRecord) error {
+
+	r.write_mu.Lock()
+	defer r.write_mu.Unlock()
+
+	g := r.current.Load()
+
+	err := g.storeRecords(ctx, records)
+
+	if err != nil {
+		return err
+	}
+
+	l := g.prepareLoad(records)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err = g.applyLoad(ctx, l)
+
+	if err != nil {
+		return err
+	}
+
+	op := func(ctx context.Context, g *generation) error {
+		return g.loadRecords(ctx, records)
+	}
+
+	r.recordWrite(op)
+	return nil
+}
+
+// recordBodyIndexRecords returns the list of `indexRecord` instances for the GeoJSON Feature, or FeatureCollection,
+// in 'body'. If a feature fails to be parsed then the records for the features before it are returned along with
+// the error.
+func (r *RTreeSpatialDatabase) recordBodyIndexRecords(ctx context.Context, body []byte) ([]*indexRecord, error) {
+
+	feature_bodies, err := featureBodies(body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*indexRecord, 0)
+
+	for i, f_body := range feature_bodies {
+
+		select {
+		case <-ctx.Done():
+			return records, ctx.Err()
+		default:
+			// pass
+		}
+
+		rec, err := r.newIndexRecord(ctx, f_body)
+
+		if err != nil {
+
+			if len(feature_bodies) == 1 {
+				return records, err
+			}
+
+			return records, fmt.Errorf("Failed to index Feature at offset %d in record, %w", i, err)
+		}
+
+		// Features that can not be indexed in non-strict mode are skipped
+
+		if rec == nil {
+			continue
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
+
+// iteratorIndexRecords returns the list of `indexRecord` instances for the records emitted by a
+// whosonfirst/go-whosonfirst-iterate/v2 iterator.
+func (r *RTreeSpatialDatabase) iteratorIndexRecords(ctx context.Context, iterator_uri string, iterator_sources ...string) ([]*indexRecord, error) {
+
+	records := make([]*indexRecord, 0)
+	mu := new(sync.Mutex)
+
+	iter_cb := func(ctx context.Context, path string, fh io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(fh)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		body_records, err := r.recordBodyIndexRecords(ctx, body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index %s, %w", path, err)
+		}
+
+		mu.Lock()
+		records = append(records, body_records...)
+		mu.Unlock()
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, iterator_uri, iter_cb)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, iterator_sources...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to iterate URIs, %w", err)
+	}
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return records, nil
+}
@@ -0,0 +1,254 @@
+package rtree
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestSpatialDatabaseIndexFeatures(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[int64]Criteria{
+		1108712253: Criteria{Longitude: -71.120168, Latitude: 42.376015, IsCurrent: 1},   // Old Cambridge
+		420561633:  Criteria{Longitude: -122.395268, Latitude: 37.794893, IsCurrent: 0},  // Superbowl City
+		420780729:  Criteria{Longitude: -122.421529, Latitude: 37.743168, IsCurrent: -1}, // Liminal Zone of Deliciousness
+	}
+
+	paths, err := filepath.Glob("fixtures/microhoods/*.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to list fixtures, %v", err)
+	}
+
+	bodies := make([][]byte, 0)
+
+	for _, path := range paths {
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", path, err)
+		}
+
+		bodies = append(bodies, body)
+	}
+
+	// Enough synthetic features to trigger bulk-loading
+
+	bodies = append(bodies, newGridFeatures(t, 100)...)
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	// Index one feature the slow way and then include a modified copy of it in the bulk load to
+	// ensure that existing entries are replaced
+
+	stale := newTestFeature(t, 1, orb.Bound{Min: orb.Point{-50, -50}, Max: orb.Point{-49, -49}}.ToPolygon())
+
+	err = db.IndexFeature(ctx, stale)
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, bodies...)
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	for expected, criteria := range tests {
+
+		c := orb.Point{criteria.Longitude, criteria.Latitude}
+
+		spr, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		found := false
+
+		for _, s := range spr.Results() {
+
+			if s.Id() == strconv.FormatInt(expected, 10) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			t.Fatalf("Expected to find %d at %v", expected, c)
+		}
+	}
+
+	for _, c := range []orb.Point{{-49.5, -49.5}, {0.5, 0.5}} {
+
+		spr, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		expected := 1
+
+		if c.X() < 0 {
+			expected = 0
+		}
+
+		if len(spr.Results()) != expected {
+			t.Fatalf("Expected %d results at %v but got %d", expected, c, len(spr.Results()))
+		}
+	}
+
+	err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, []byte("{}"))
+
+	if err == nil {
+		t.Fatalf("Expected invalid feature to fail")
+	}
+}
+
+func TestSpatialDatabaseIndexFeaturesFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://?cache=lru&cache_size=2")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	b1 := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+
+	err = rtree_db.IndexFeatures(ctx, newTestFeature(t, 1, b1.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	// The cache only has room for one more item so storing the cache item for the third feature fails and the
+	// cache items already stored for the first two should be rolled back
+
+	bodies := [][]byte{
+		newTestFeature(t, 1, orb.Bound{Min: orb.Point{10, 10}, Max: orb.Point{11, 11}}.ToPolygon()),
+		newTestFeature(t, 2, orb.Bound{Min: orb.Point{20, 20}, Max: orb.Point{21, 21}}.ToPolygon()),
+		newTestFeature(t, 3, orb.Bound{Min: orb.Point{30, 30}, Max: orb.Point{31, 31}}.ToPolygon()),
+	}
+
+	err = rtree_db.IndexFeatures(ctx, bodies...)
+
+	if err == nil {
+		t.Fatalf("Expected indexing features in to a full cache to fail")
+	}
+
+	g := rtree_db.current.Load()
+
+	count, err := g.cache.Count(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to count cache items, %v", err)
+	}
+
+	if count != 1 || g.rtree.Size() != 1 {
+		t.Fatalf("Expected 1 cache item and rtree entry but got %d and %d", count, g.rtree.Size())
+	}
+
+	pip_tests := map[orb.Point][]string{
+		{0.5, 0.5}:   {"1"},
+		{10.5, 10.5}: {},
+	}
+
+	for c, expected := range pip_tests {
+
+		rsp, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertIds(t, rsp.Results(), expected)
+	}
+}
+
+func BenchmarkIndexFeature(b *testing.B) {
+
+	ctx := context.Background()
+	bodies := newGridFeatures(b, 5000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+		if err != nil {
+			b.Fatalf("Failed to create new spatial database, %v", err)
+		}
+
+		for _, body := range bodies {
+
+			err := db.IndexFeature(ctx, body)
+
+			if err != nil {
+				b.Fatalf("Failed to index feature, %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkIndexFeatures(b *testing.B) {
+
+	ctx := context.Background()
+	bodies := newGridFeatures(b, 5000)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+
+		db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+		if err != nil {
+			b.Fatalf("Failed to create new spatial database, %v", err)
+		}
+
+		err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, bodies...)
+
+		if err != nil {
+			b.Fatalf("Failed to index features, %v", err)
+		}
+	}
+}
+
+// newGridFeatures returns 'count' features whose geometries are one degree squares laid out in a grid
+// starting at 0,0. The first feature has ID 1.
+func newGridFeatures(t testing.TB, count int) [][]byte {
+
+	bodies := make([][]byte, count)
+
+	for i := 0; i < count; i++ {
+
+		x := float64(i % 100)
+		y := float64(i / 100)
+
+		b := orb.Bound{Min: orb.Point{x, y}, Max: orb.Point{x + 1, y + 1}}
+		bodies[i] = newTestFeature(t, int64(i+1), b.ToPolygon())
+	}
+
+	return bodies
+}
@@ -0,0 +1,127 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// The names of the cache backends that may be assigned to the `cache` parameter of a `rtree://` URI.
+const (
+	cacheGoCache string = "gocache"
+	cacheLRU     string = "lru"
+	cacheDisk    string = "disk"
+)
+
+// The default maximum number of items in a LRU cache.
+const defaultCacheSize int = 10000
+
+// featureCache is the interface for storing the geometry and SPR records (and optionally the original bodies)
+// of indexed features. Cache keys are derived using `cacheKey`.
+type featureCache interface {
+	// Get returns the cache item for 'key' or an error if it does not exist.
+	Get(context.Context, string) (*RTreeCache, error)
+	// Set stores a cache item for 'key', replacing any existing item.
+	Set(context.Context, string, *RTreeCache) error
+	// Delete removes the cache item for 'key'. It is not an error if the item does not exist.
+	Delete(context.Context, string) error
+	// Count returns the number of items in the cache.
+	Count(context.Context) (int, error)
+	// Iterate calls a function for every item in the cache.
+	Iterate(context.Context, func(string, *RTreeCache) error) error
+	// Close releases any resources used by the cache.
+	Close(context.Context) error
+}
+
+// newFeatureCache returns a new `featureCache` instance derived from the `cache` parameter (and backend-specific
+// parameters) in 'q'. If `cache` is empty then the gocache backend is used.
+func newFeatureCache(ctx context.Context, q url.Values) (featureCache, error) {
+
+	size := defaultCacheSize
+
+	str_size := q.Get("cache_size")
+
+	if str_size != "" {
+
+		v, err := strconv.Atoi(str_size)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cache_size parameter, %w", err)
+		}
+
+		size = v
+	}
+
+	if size < 0 {
+		return nil, fmt.Errorf("Invalid cache_size parameter, must not be negative")
+	}
+
+	switch q.Get("cache") {
+	case "", cacheGoCache:
+
+		expires := 0 * time.Second
+		cleanup := 0 * time.Second
+
+		str_exp := q.Get("default_expiration")
+		str_cleanup := q.Get("cleanup_interval")
+
+		if str_exp != "" {
+
+			int_expires, err := strconv.Atoi(str_exp)
+
+			if err != nil {
+				return nil, err
+			}
+
+			expires = time.Duration(int_expires) * time.Second
+		}
+
+		if str_cleanup != "" {
+
+			int_cleanup, err := strconv.Atoi(str_cleanup)
+
+			if err != nil {
+				return nil, err
+			}
+
+			cleanup = time.Duration(int_cleanup) * time.Second
+		}
+
+		return newGoCache(expires, cleanup), nil
+
+	case cacheLRU:
+
+		if size == 0 {
+			return nil, fmt.Errorf("Invalid cache_size parameter, must be greater than zero")
+		}
+
+		// Without a backing store evicted items would be lost, and their features silently excluded from
+		// query results, so new items are rejected once the cache is full
+
+		c := newLRUCache(size, nil)
+		c.reject = true
+
+		return c, nil
+
+	case cacheDisk:
+
+		c, err := newDiskCache(ctx, q.Get("cache_root"))
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create disk cache, %w", err)
+		}
+
+		// Only keep recently used items in memory if a size has been explicitly set
+
+		if str_size == "" || size == 0 {
+			return c, nil
+		}
+
+		return newLRUCache(size, c), nil
+
+	default:
+		return nil, fmt.Errorf("Invalid cache parameter '%s'", q.Get("cache"))
+	}
+}
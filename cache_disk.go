@@ -0,0 +1,317 @@
+package rtree
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diskCache is a `featureCache` implementation that stores each item as a JSON-encoded file in a directory.
+// Items are encoded the same way as the records in a snapshot.
+type diskCache struct {
+	root string
+	// Whether 'root' was created by the cache, in which case it is removed when the cache is closed
+	is_temp bool
+}
+
+// newDiskCache returns a new `diskCache` instance storing items in 'root'. If 'root' is empty then a new
+// temporary directory is created. Any items (and directories used by the caches of rebuilt generations) left in
+// 'root' by a previous database are removed since they are not in its index.
+func newDiskCache(ctx context.Context, root string) (featureCache, error) {
+
+	if root == "" {
+		return newTempDiskCache(ctx, "")
+	}
+
+	err := os.MkdirAll(root, 0755)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create %s, %w", root, err)
+	}
+
+	c := &diskCache{
+		root: root,
+	}
+
+	err = c.clear(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to remove existing items from %s, %w", root, err)
+	}
+
+	entries, err := os.ReadDir(root)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", root, err)
+	}
+
+	for _, e := range entries {
+
+		if !e.IsDir() || !strings.HasPrefix(e.Name(), tempDiskCachePrefix) {
+			continue
+		}
+
+		path := filepath.Join(root, e.Name())
+
+		err := os.RemoveAll(path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to remove %s, %w", path, err)
+		}
+	}
+
+	return c, nil
+}
+
+// The prefix for the names of the directories created by `newTempDiskCache`.
+const tempDiskCachePrefix string = "rtree-cache"
+
+// newTempDiskCache returns a new `diskCache` instance storing items in a new directory inside 'parent' (or the
+// default directory for temporary files if 'parent' is empty) which is removed when the cache is closed.
+func newTempDiskCache(ctx context.Context, parent string) (featureCache, error) {
+
+	if parent != "" {
+
+		err := os.MkdirAll(parent, 0755)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create %s, %w", parent, err)
+		}
+	}
+
+	root, err := os.MkdirTemp(parent, tempDiskCachePrefix)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create temporary directory, %w", err)
+	}
+
+	c := &diskCache{
+		root:    root,
+		is_temp: true,
+	}
+
+	return c, nil
+}
+
+func (c *diskCache) Get(ctx context.Context, key string) (*RTreeCache, error) {
+
+	path := c.path(key)
+
+	body, err := os.ReadFile(path)
+
+	if err != nil {
+
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("Invalid cache ID '%s'", key)
+		}
+
+		return nil, fmt.Errorf("Failed to read %s, %w", path, err)
+	}
+
+	rec, err := decodeDiskCacheRecord(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode %s, %w", path, err)
+	}
+
+	return rec.cacheItem()
+}
+
+func (c *diskCache) Set(ctx context.Context, key string, cache_item *RTreeCache) error {
+
+	rec, err := newSnapshotRecord(key, cache_item)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create record for %s, %w", key, err)
+	}
+
+	body, err := json.Marshal(rec)
+
+	if err != nil {
+		return fmt.Errorf("Failed to marshal record for %s, %w", key, err)
+	}
+
+	path := c.path(key)
+
+	wr, err := os.CreateTemp(c.root, filepath.Base(path))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s, %w", path, err)
+	}
+
+	tmp_path := wr.Name()
+	defer os.Remove(tmp_path)
+
+	_, err = wr.Write(body)
+
+	if err != nil {
+		wr.Close()
+		return fmt.Errorf("Failed to write %s, %w", tmp_path, err)
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", tmp_path, err)
+	}
+
+	err = os.Rename(tmp_path, path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to move %s to %s, %w", tmp_path, path, err)
+	}
+
+	return nil
+}
+
+func (c *diskCache) Delete(ctx context.Context, key string) error {
+
+	path := c.path(key)
+
+	err := os.Remove(path)
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("Failed to remove %s, %w", path, err)
+	}
+
+	return nil
+}
+
+func (c *diskCache) Count(ctx context.Context) (int, error) {
+
+	paths, err := c.paths()
+
+	if err != nil {
+		return 0, err
+	}
+
+	return len(paths), nil
+}
+
+func (c *diskCache) Iterate(ctx context.Context, cb func(string, *RTreeCache) error) error {
+
+	paths, err := c.paths()
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		body, err := os.ReadFile(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to read %s, %w", path, err)
+		}
+
+		rec, err := decodeDiskCacheRecord(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decode %s, %w", path, err)
+		}
+
+		cache_item, err := rec.cacheItem()
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive cache item for %s, %w", path, err)
+		}
+
+		err = cb(rec.Key, cache_item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *diskCache) Close(ctx context.Context) error {
+
+	if !c.is_temp {
+		return nil
+	}
+
+	return os.RemoveAll(c.root)
+}
+
+// clear removes all the cache item files in the cache's root directory. Subdirectories, for example the
+// directories used by the caches of other generations, are left alone.
+func (c *diskCache) clear(ctx context.Context) error {
+
+	paths, err := c.paths()
+
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+
+		err := os.Remove(path)
+
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("Failed to remove %s, %w", path, err)
+		}
+	}
+
+	return nil
+}
+
+// path returns the path of the file for 'key'. Keys are hex-encoded since alt labels may contain characters
+// that are not safe to use in filenames.
+func (c *diskCache) path(key string) string {
+	fname := fmt.Sprintf("%s.json", hex.EncodeToString([]byte(key)))
+	return filepath.Join(c.root, fname)
+}
+
+// paths returns the list of cache item files in the cache's root directory.
+func (c *diskCache) paths() ([]string, error) {
+
+	entries, err := os.ReadDir(c.root)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", c.root, err)
+	}
+
+	paths := make([]string, 0)
+
+	for _, e := range entries {
+
+		// Skip directories and any temporary files left over from interrupted writes
+
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+
+		paths = append(paths, filepath.Join(c.root, e.Name()))
+	}
+
+	return paths, nil
+}
+
+// decodeDiskCacheRecord decodes the contents of a cache item file.
+func decodeDiskCacheRecord(body []byte) (*snapshotRecord, error) {
+
+	var rec *snapshotRecord
+
+	err := json.Unmarshal(body, &rec)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal cache record, %w", err)
+	}
+
+	return rec, nil
+}
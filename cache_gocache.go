@@ -0,0 +1,74 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	gocache "github.com/patrickmn/go-cache"
+)
+
+// goCache is a `featureCache` implementation that stores items in memory using patrickmn/go-cache.
+type goCache struct {
+	cache *gocache.Cache
+}
+
+func newGoCache(expires time.Duration, cleanup time.Duration) featureCache {
+
+	c := &goCache{
+		cache: gocache.New(expires, cleanup),
+	}
+
+	return c
+}
+
+func (c *goCache) Get(ctx context.Context, key string) (*RTreeCache, error) {
+
+	cache_item, ok := c.cache.Get(key)
+
+	if !ok {
+		return nil, fmt.Errorf("Invalid cache ID '%s'", key)
+	}
+
+	return cache_item.(*RTreeCache), nil
+}
+
+func (c *goCache) Set(ctx context.Context, key string, cache_item *RTreeCache) error {
+	c.cache.Set(key, cache_item, -1)
+	return nil
+}
+
+func (c *goCache) Delete(ctx context.Context, key string) error {
+	c.cache.Delete(key)
+	return nil
+}
+
+func (c *goCache) Count(ctx context.Context) (int, error) {
+	return c.cache.ItemCount(), nil
+}
+
+func (c *goCache) Iterate(ctx context.Context, cb func(string, *RTreeCache) error) error {
+
+	for key, item := range c.cache.Items() {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		err := cb(key, item.Object.(*RTreeCache))
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *goCache) Close(ctx context.Context) error {
+	c.cache.Flush()
+	return nil
+}
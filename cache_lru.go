@@ -0,0 +1,245 @@
+package rtree
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// lruCache is a `featureCache` implementation that keeps at most 'size' items in memory, evicting the least
+// recently used item when full. If 'next' is not nil then it is treated as the canonical store for all items:
+// writes are passed through to it and items missing from memory are read from it. If 'next' is nil then evicted
+// items are lost so, unless the cache is only used to keep things which can be read again from elsewhere (like
+// the geometry cache), 'reject' should be true.
+type lruCache struct {
+	size  int
+	next  featureCache
+	items map[string]*list.Element
+	order *list.List
+	// The fill token for each key which is being read from 'next' after a miss. Set and Delete remove a key's
+	// token so that an item read from 'next' before the write completed is not put back in memory.
+	fills      map[string]uint64
+	last_token uint64
+	// If true then Set fails, rather than evicting an item, if adding a new item would exceed 'size'
+	reject bool
+	mu     *sync.Mutex
+}
+
+// errCacheFull is returned (wrapped) by `lruCache.Set` when the cache is full and it rejects new items rather than
+// evicting existing ones.
+var errCacheFull = errors.New("cache is full")
+
+type lruEntry struct {
+	Key  string
+	Item *RTreeCache
+}
+
+func newLRUCache(size int, next featureCache) *lruCache {
+
+	c := &lruCache{
+		size:  size,
+		next:  next,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+		fills: make(map[string]uint64),
+		mu:    new(sync.Mutex),
+	}
+
+	return c
+}
+
+func (c *lruCache) Get(ctx context.Context, key string) (*RTreeCache, error) {
+
+	c.mu.Lock()
+	el, ok := c.items[key]
+
+	if ok {
+		c.order.MoveToFront(el)
+		c.mu.Unlock()
+		return el.Value.(*lruEntry).Item, nil
+	}
+
+	if c.next == nil {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("Invalid cache ID '%s'", key)
+	}
+
+	c.last_token += 1
+	token := c.last_token
+	c.fills[key] = token
+
+	c.mu.Unlock()
+
+	cache_item, err := c.next.Get(ctx, key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Only put the item in memory if the key has not been written to, or read by another miss, in the meantime
+
+	fill := c.fills[key] == token
+
+	if fill {
+		delete(c.fills, key)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if fill {
+		c.addLocked(key, cache_item)
+	}
+
+	return cache_item, nil
+}
+
+func (c *lruCache) Set(ctx context.Context, key string, cache_item *RTreeCache) error {
+
+	if c.next != nil {
+
+		err := c.next.Set(ctx, key, cache_item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.add(key, cache_item)
+}
+
+func (c *lruCache) Delete(ctx context.Context, key string) error {
+
+	// The item is removed from 'next' first so that a concurrent miss can not read it back in to memory
+
+	if c.next != nil {
+
+		err := c.next.Delete(ctx, key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+
+	if ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+	}
+
+	delete(c.fills, key)
+	return nil
+}
+
+func (c *lruCache) Count(ctx context.Context) (int, error) {
+
+	if c.next != nil {
+		return c.next.Count(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.items), nil
+}
+
+func (c *lruCache) Iterate(ctx context.Context, cb func(string, *RTreeCache) error) error {
+
+	if c.next != nil {
+		return c.next.Iterate(ctx, cb)
+	}
+
+	// Copy the entries so that 'cb' is not called while the lock is held
+
+	c.mu.Lock()
+
+	entries := make([]*lruEntry, 0, len(c.items))
+
+	for el := c.order.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*lruEntry))
+	}
+
+	c.mu.Unlock()
+
+	for _, e := range entries {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		err := cb(e.Key, e.Item)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *lruCache) Close(ctx context.Context) error {
+
+	c.mu.Lock()
+	c.items = make(map[string]*list.Element)
+	c.fills = make(map[string]uint64)
+	c.order.Init()
+	c.mu.Unlock()
+
+	if c.next != nil {
+		return c.next.Close(ctx)
+	}
+
+	return nil
+}
+
+// add adds (or replaces) 'cache_item' in memory, evicting the least recently used item if necessary or, if the
+// cache rejects new items when it is full, returning an error. Any pending fill for 'key' is cancelled.
+func (c *lruCache) add(key string, cache_item *RTreeCache) error {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, exists := c.items[key]
+
+	if c.reject && !exists && len(c.items) >= c.size {
+		return fmt.Errorf("%w (cache_size is %d)", errCacheFull, c.size)
+	}
+
+	delete(c.fills, key)
+	c.addLocked(key, cache_item)
+
+	return nil
+}
+
+// addLocked adds (or replaces) 'cache_item' in memory, evicting the least recently used item if necessary. It is
+// assumed that the caller holds the lock.
+func (c *lruCache) addLocked(key string, cache_item *RTreeCache) {
+
+	el, ok := c.items[key]
+
+	if ok {
+		el.Value.(*lruEntry).Item = cache_item
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruEntry{Key: key, Item: cache_item})
+
+	for c.order.Len() > c.size {
+
+		oldest := c.order.Back()
+		oldest_key := oldest.Value.(*lruEntry).Key
+
+		c.order.Remove(oldest)
+		delete(c.items, oldest_key)
+	}
+}
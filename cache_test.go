@@ -0,0 +1,449 @@
+package rtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestFeatureCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache_root := t.TempDir()
+
+	tests := []string{
+		"cache=gocache",
+		"cache=lru",
+		"cache=disk&cache_root=" + cache_root,
+		"cache=disk&cache_size=1",
+	}
+
+	_, cache_item, err := new(RTreeSpatialDatabase).newCacheItem(ctx, newTestFeature(t, 1, orb.Point{1, 1}))
+
+	if err != nil {
+		t.Fatalf("Failed to create cache item, %v", err)
+	}
+
+	for _, str_q := range tests {
+
+		q, err := url.ParseQuery(str_q)
+
+		if err != nil {
+			t.Fatalf("Failed to parse %s, %v", str_q, err)
+		}
+
+		c, err := newFeatureCache(ctx, q)
+
+		if err != nil {
+			t.Fatalf("Failed to create cache for %s, %v", str_q, err)
+		}
+
+		for _, key := range []string{"1:", "2:", "2:quattroshapes"} {
+
+			err := c.Set(ctx, key, cache_item)
+
+			if err != nil {
+				t.Fatalf("Failed to set %s for %s, %v", key, str_q, err)
+			}
+		}
+
+		err = c.Delete(ctx, "2:")
+
+		if err != nil {
+			t.Fatalf("Failed to delete item for %s, %v", str_q, err)
+		}
+
+		_, err = c.Get(ctx, "2:")
+
+		if err == nil {
+			t.Fatalf("Expected deleted item to be missing for %s", str_q)
+		}
+
+		v, err := c.Get(ctx, "2:quattroshapes")
+
+		if err != nil {
+			t.Fatalf("Failed to get item for %s, %v", str_q, err)
+		}
+
+		if v.SPR.Id() != "1" || !v.Geometry.Geometry().Bound().Equal(cache_item.Geometry.Geometry().Bound()) {
+			t.Fatalf("Unexpected cache item for %s", str_q)
+		}
+
+		count, err := c.Count(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to count items for %s, %v", str_q, err)
+		}
+
+		keys := make([]string, 0)
+
+		err = c.Iterate(ctx, func(key string, cache_item *RTreeCache) error {
+			keys = append(keys, key)
+			return nil
+		})
+
+		if err != nil {
+			t.Fatalf("Failed to iterate items for %s, %v", str_q, err)
+		}
+
+		if count != 2 || len(keys) != 2 {
+			t.Fatalf("Expected 2 items for %s but got %d (%v)", str_q, count, keys)
+		}
+
+		err = c.Close(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to close cache for %s, %v", str_q, err)
+		}
+	}
+
+	_, err = os.Stat(filepath.Join(cache_root, fmt.Sprintf("%x.json", "1:")))
+
+	if err != nil {
+		t.Fatalf("Expected disk cache with explicit root to persist after closing, %v", err)
+	}
+
+	for _, str_q := range []string{"cache=unknown", "cache=lru&cache_size=0", "cache_size=-1"} {
+
+		q, _ := url.ParseQuery(str_q)
+
+		_, err := newFeatureCache(ctx, q)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", str_q)
+		}
+	}
+}
+
+func TestSpatialDatabaseDiskCacheReopen(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache_root := t.TempDir()
+	database_uri := fmt.Sprintf("rtree://?cache=disk&cache_root=%s", cache_root)
+
+	db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	b := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+	err = db.IndexFeature(ctx, newTestFeature(t, 123, b.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	// Leave a directory behind as though a rebuild had been interrupted
+
+	err = os.Mkdir(filepath.Join(cache_root, tempDiskCachePrefix+"123"), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create directory, %v", err)
+	}
+
+	err = db.Close(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to close database, %v", err)
+	}
+
+	// A new database using the same cache_root has not indexed anything so it should not see the items left
+	// behind by the first one
+
+	db, err = NewRTreeSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	_, err = db.Read(ctx, "123.geojson")
+
+	if err == nil {
+		t.Fatalf("Expected feature indexed by a previous database to be absent")
+	}
+
+	count, err := db.(*RTreeSpatialDatabase).current.Load().cache.Count(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to count cache items, %v", err)
+	}
+
+	if count != 0 {
+		t.Fatalf("Expected cache to be empty but it has %d items", count)
+	}
+
+	entries, err := os.ReadDir(cache_root)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", cache_root, err)
+	}
+
+	if len(entries) != 0 {
+		t.Fatalf("Expected %s to be empty but it has %d entries", cache_root, len(entries))
+	}
+}
+
+func TestFeatureCacheLRUEviction(t *testing.T) {
+
+	ctx := context.Background()
+
+	c := newLRUCache(2, nil)
+	cache_item := &RTreeCache{}
+
+	for _, key := range []string{"1:", "2:"} {
+		c.Set(ctx, key, cache_item)
+	}
+
+	// Use "1:" so that "2:" is the least recently used item
+
+	c.Get(ctx, "1:")
+	c.Set(ctx, "3:", cache_item)
+
+	for key, expected := range map[string]bool{"1:": true, "2:": false, "3:": true} {
+
+		_, err := c.Get(ctx, key)
+
+		if (err == nil) != expected {
+			t.Fatalf("Unexpected presence of %s in cache, %v", key, err)
+		}
+	}
+}
+
+func TestSpatialDatabaseCacheBackends(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, database_uri := range []string{
+		"rtree://?cache=lru&cache_size=10",
+		"rtree://?cache=disk",
+		"rtree://?cache=disk&cache_size=1",
+	} {
+
+		db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		for id, b := range map[int64]orb.Bound{
+			1: {Min: orb.Point{0, 0}, Max: orb.Point{1, 1}},
+			2: {Min: orb.Point{5, 5}, Max: orb.Point{6, 6}},
+		} {
+
+			err := db.IndexFeature(ctx, newTestFeature(t, id, b.ToPolygon()))
+
+			if err != nil {
+				t.Fatalf("Failed to index feature for %s, %v", database_uri, err)
+			}
+		}
+
+		for _, c := range []orb.Point{{0.5, 0.5}, {5.5, 5.5}} {
+
+			spr, err := db.PointInPolygon(ctx, &c)
+
+			if err != nil {
+				t.Fatalf("Failed to perform point in polygon query for %s, %v", database_uri, err)
+			}
+
+			if len(spr.Results()) != 1 {
+				t.Fatalf("Expected 1 result at %v for %s but got %d", c, database_uri, len(spr.Results()))
+			}
+		}
+
+		snapshot_path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+		err = db.(*RTreeSpatialDatabase).WriteSnapshotFile(ctx, snapshot_path)
+
+		if err != nil {
+			t.Fatalf("Failed to write snapshot for %s, %v", database_uri, err)
+		}
+
+		err = db.Close(ctx)
+
+		if err != nil {
+			t.Fatalf("Failed to close database for %s, %v", database_uri, err)
+		}
+	}
+}
+
+// pausingCache is a `featureCache` whose Get method, once it has read an item, waits for 'resume' to be
+// notified before returning it.
+type pausingCache struct {
+	featureCache
+	paused chan bool
+	resume chan bool
+}
+
+func (c *pausingCache) Get(ctx context.Context, key string) (*RTreeCache, error) {
+
+	cache_item, err := c.featureCache.Get(ctx, key)
+
+	c.paused <- true
+	<-c.resume
+
+	return cache_item, err
+}
+
+func TestFeatureCacheLRUFill(t *testing.T) {
+
+	ctx := context.Background()
+
+	old_item := &RTreeCache{}
+	new_item := &RTreeCache{}
+
+	writes := map[string]func(featureCache) error{
+		"set": func(c featureCache) error {
+			return c.Set(ctx, "1:", new_item)
+		},
+		"delete": func(c featureCache) error {
+			return c.Delete(ctx, "1:")
+		},
+	}
+
+	for label, write_func := range writes {
+
+		next := &pausingCache{
+			featureCache: newGoCache(0, 0),
+			paused:       make(chan bool),
+			resume:       make(chan bool),
+		}
+
+		next.featureCache.Set(ctx, "1:", old_item)
+
+		c := newLRUCache(10, next)
+
+		// Miss, and read the old item from 'next', then write to the key before the miss completes
+
+		done_ch := make(chan bool)
+
+		go func() {
+			c.Get(ctx, "1:")
+			done_ch <- true
+		}()
+
+		<-next.paused
+
+		err := write_func(c)
+
+		if err != nil {
+			t.Fatalf("Failed to %s item, %v", label, err)
+		}
+
+		next.resume <- true
+		<-done_ch
+
+		// The old item should not have been put back in memory so the next Get either misses (and reads the
+		// new item from 'next') or returns the new item from memory
+
+		go func() {
+
+			for range next.paused {
+				next.resume <- true
+			}
+		}()
+
+		cache_item, err := c.Get(ctx, "1:")
+
+		close(next.paused)
+
+		switch label {
+		case "set":
+
+			if err != nil || cache_item != new_item {
+				t.Fatalf("Expected new item after set, %v", err)
+			}
+
+		case "delete":
+
+			if err == nil {
+				t.Fatalf("Expected deleted item to be absent")
+			}
+		}
+	}
+}
+
+func TestSpatialDatabaseLRUCacheFull(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://?cache=lru&cache_size=1")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	b1 := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+	b2 := orb.Bound{Min: orb.Point{5, 5}, Max: orb.Point{6, 6}}
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, b1.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	// The cache has no backing store so it should reject the second feature rather than evicting the first
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 2, b2.ToPolygon()))
+
+	if !errors.Is(err, errCacheFull) {
+		t.Fatalf("Expected indexing a feature in to a full cache to fail, %v", err)
+	}
+
+	// Re-indexing a feature replaces its existing item so it should succeed
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, b1.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to re-index feature, %v", err)
+	}
+
+	pip_tests := map[orb.Point][]string{
+		{0.5, 0.5}: {"1"},
+		{5.5, 5.5}: {},
+	}
+
+	for c, expected := range pip_tests {
+
+		rsp, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertIds(t, rsp.Results(), expected)
+	}
+
+	snapshot_path := filepath.Join(t.TempDir(), "lru.snapshot")
+
+	err = db.(*RTreeSpatialDatabase).WriteSnapshotFile(ctx, snapshot_path)
+
+	if err != nil {
+		t.Fatalf("Failed to write snapshot, %v", err)
+	}
+
+	snapshot_db, err := NewRTreeSpatialDatabase(ctx, fmt.Sprintf("rtree://?snapshot=%s", snapshot_path))
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database from snapshot, %v", err)
+	}
+
+	defer snapshot_db.Close(ctx)
+
+	if snapshot_db.(*RTreeSpatialDatabase).current.Load().rtree.Size() != 1 {
+		t.Fatalf("Expected snapshot to contain 1 entry")
+	}
+}
@@ -6,12 +6,13 @@ import (
 	"fmt"
 	"github.com/sfomuseum/go-flags/flagset"
 	"github.com/sfomuseum/go-flags/lookup"
-	_ "github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+	"github.com/whosonfirst/go-whosonfirst-spatial-rtree"
 	"github.com/whosonfirst/go-whosonfirst-spatial/database"
 	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
 	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
 	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
 	"log"
+	"net/url"
 )
 
 func main() {
@@ -57,6 +58,8 @@ func main() {
 	database_uri, _ := lookup.StringVar(fs, "spatial-database-uri")
 	iterator_uri, _ := lookup.StringVar(fs, "iterator-uri")
 
+	sort_uris, _ := lookup.MultiStringVar(fs, flags.SortURIFlag)
+
 	latitude, _ := lookup.Float64Var(fs, "latitude")
 	longitude, _ := lookup.Float64Var(fs, "longitude")
 
@@ -64,13 +67,33 @@ func main() {
 
 	ctx := context.Background()
 
+	// Sort URIs are applied by the database itself so they are appended to its URI as sort_uri parameters
+
+	if len(sort_uris) > 0 {
+
+		u, err := url.Parse(database_uri)
+
+		if err != nil {
+			log.Fatalf("Failed to parse database URI '%s', %v", database_uri, err)
+		}
+
+		q := u.Query()
+
+		for _, sort_uri := range sort_uris {
+			q.Add("sort_uri", sort_uri)
+		}
+
+		// url.URL.String drops the "//" from URIs without a host (for example "rtree://")
+		database_uri = fmt.Sprintf("%s://%s%s?%s", u.Scheme, u.Host, u.Path, q.Encode())
+	}
+
 	db, err := database.NewSpatialDatabase(ctx, database_uri)
 
 	if err != nil {
 		log.Fatalf("Failed to create database for '%s', %v", database_uri, err)
 	}
 
-	err = database.IndexDatabaseWithIterator(ctx, db, iterator_uri, iterator_sources...)
+	err = rtree.IndexDatabaseWithIterator(ctx, db, iterator_uri, iterator_sources...)
 
 	if err != nil {
 		log.Fatalf("Failed to index database with iterator, %v", err)
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/sfomuseum/go-flags/flagset"
+	"github.com/sfomuseum/go-flags/lookup"
+	"github.com/whosonfirst/go-whosonfirst-spatial-rtree"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/flags"
+)
+
+func main() {
+
+	fs, err := flags.CommonFlags()
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = flags.AppendIndexingFlags(fs)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fs.String("snapshot", "", "The path where the snapshot of the indexed database should be written.")
+	fs.Int("workers", 0, "The number of workers used to index records. If 0 then the number of CPUs is used.")
+	fs.Bool("bulk", true, "If true then records are buffered and the rtree is bulk-loaded once all records have been read, rather than indexing records one at a time.")
+	fs.Bool("allow-failures", false, "If true then records that fail to be indexed are logged but do not prevent a snapshot from being written.")
+
+	flagset.Parse(fs)
+
+	err = flags.ValidateCommonFlags(fs)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = flags.ValidateIndexingFlags(fs)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	database_uri, _ := lookup.StringVar(fs, "spatial-database-uri")
+	iterator_uri, _ := lookup.StringVar(fs, "iterator-uri")
+	snapshot_path, _ := lookup.StringVar(fs, "snapshot")
+	workers, _ := lookup.IntVar(fs, "workers")
+	allow_failures, _ := lookup.BoolVar(fs, "allow-failures")
+	bulk, _ := lookup.BoolVar(fs, "bulk")
+
+	if snapshot_path == "" {
+		log.Fatalf("Missing -snapshot flag")
+	}
+
+	iterator_sources := fs.Args()
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		log.Fatalf("Failed to create database for '%s', %v", database_uri, err)
+	}
+
+	rtree_db, ok := db.(*rtree.RTreeSpatialDatabase)
+
+	if !ok {
+		log.Fatalf("Database for '%s' is not an rtree database", database_uri)
+	}
+
+	opts := &rtree.IndexPipelineOptions{
+		Workers: workers,
+		Bulk:    bulk,
+	}
+
+	summary, err := rtree.IndexDatabaseWithPipeline(ctx, db, opts, iterator_uri, iterator_sources...)
+
+	if err != nil {
+		log.Fatalf("Failed to index database with iterator, %v", err)
+	}
+
+	for _, f := range summary.Failures {
+		log.Printf("Failed to index %s, %v", f.Path, f.Error)
+	}
+
+	if len(summary.Failures) > 0 && !allow_failures {
+		log.Fatalf("Failed to index %d records", len(summary.Failures))
+	}
+
+	err = rtree_db.WriteSnapshotFile(ctx, snapshot_path)
+
+	if err != nil {
+		log.Fatalf("Failed to write snapshot, %v", err)
+	}
+
+	fmt.Println(snapshot_path)
+}
@@ -8,25 +8,28 @@ import (
 	"io"
 	"log"
 	"log/slog"
+	"math"
 	"net/url"
+	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
 
 	"github.com/dhconnelly/rtreego"
-	gocache "github.com/patrickmn/go-cache"
 	"github.com/paulmach/orb"
 	"github.com/paulmach/orb/geojson"
 	"github.com/paulmach/orb/planar"
 	"github.com/whosonfirst/go-ioutil"
+	"github.com/whosonfirst/go-reader"
 	"github.com/whosonfirst/go-whosonfirst-feature/alt"
 	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
 	"github.com/whosonfirst/go-whosonfirst-feature/properties"
 	"github.com/whosonfirst/go-whosonfirst-spatial"
 	"github.com/whosonfirst/go-whosonfirst-spatial/database"
-	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
 	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2/sort"
 	"github.com/whosonfirst/go-whosonfirst-uri"
 )
 
@@ -38,31 +41,183 @@ func init() {
 type RTreeCache struct {
 	Geometry *geojson.Geometry        `json:"geometry"`
 	SPR      spr.StandardPlacesResult `json:"properties"`
+	// Body is the original feature body, only populated if the database was created with `store_raw=true`.
+	Body []byte `json:"body,omitempty"`
+	// BodyCompressed indicates whether Body has been gzip-compressed.
+	BodyCompressed bool `json:"body_compressed,omitempty"`
+	// EncodedGeometry is the compact encoding of the geometry, only populated (instead of Geometry) if the
+	// database was created with a `geometry_encoding` parameter.
+	EncodedGeometry []byte `json:"encoded_geometry,omitempty"`
+	// GeometryEncoding is the encoding used for EncodedGeometry.
+	GeometryEncoding string `json:"geometry_encoding,omitempty"`
 }
 
-// PLEASE DISCUSS WHY patrickm/go-cache AND NOT whosonfirst/go-cache HERE
-
 type RTreeSpatialDatabase struct {
 	database.SpatialDatabase
 	index_alt_files bool
-	rtree           *rtreego.Rtree
-	gocache         *gocache.Cache
-	mu              *sync.RWMutex
-	strict          bool
+	// current is the generation (the rtree, lookup table and cache) used by new queries
+	current *atomic.Pointer[generation]
+	// The parameters used to create the cache for new generations
+	cache_params url.Values
+	// mu guards the rtree and lookup table of the current generation and keeps them consistent with its cache.
+	// Searches take a read lock while anything that adds or removes entries takes a write lock.
+	mu *sync.RWMutex
+	// write_mu serializes writes so that bulk loads can build a new rtree, from the current lookup table, without
+	// holding mu. It is always acquired before mu.
+	write_mu *sync.Mutex
+	// If not nil then a rebuild is in progress and writes to the current generation are also recorded here
+	journal       []generationOp
+	rebuild_mu    *sync.Mutex
+	strict        bool
+	store_raw     bool
+	compress_raw  bool
+	min_children  int
+	max_children  int
+	point_epsilon float64
+	// The maximum number of goroutines used to test the candidates for a query
+	query_workers int
+	// The sorters used to sort query results
+	sorters []sort.Sorter
+	// If not nil then geometries are not kept in the cache but read on demand using geometry_reader
+	geometry_reader reader.Reader
+	// The number of recently used geometries read from geometry_reader that each generation keeps in memory
+	geometry_cache_size int
+	// If not empty then geometries are stored in the cache using this compact encoding
+	geometry_encoding string
 }
 
+// RTreeSpatialIndex is an entry in the rtree. Its Id is "{FEATURE_ID}#{ALT_LABEL}:{PART}:{RING}" where
+// PART is the offset of the polygon (or line or point) in a multi-part geometry and RING is the offset of
+// the ring, within that polygon, whose bounds are indexed. Since only exterior rings are indexed RING is
+// always 0.
 type RTreeSpatialIndex struct {
 	Rect      *rtreego.Rect
 	Id        string
 	FeatureId string
 	IsAlt     bool
 	AltLabel  string
+	Part      int
 }
 
 func (i *RTreeSpatialIndex) Bounds() rtreego.Rect {
 	return *i.Rect
 }
 
+// The default minimum and maximum number of children for each node in the rtree.
+const defaultMinChildren int = 25
+
+const defaultMaxChildren int = 50
+
+// The default length, in degrees, of the sides of the bounding box used to query the rtree for a single point.
+const defaultPointEpsilon float64 = 0.0001
+
+// Queries with this many, or fewer, candidates test them sequentially rather than using a pool of goroutines.
+const sequentialCandidates int = 8
+
+// The list of query parameters that may be included in a `rtree://` URI. In strict mode any other
+// parameter will cause `NewRTreeSpatialDatabase` to return an error.
+var validURIParameters = []string{
+	"strict",
+	"default_expiration",
+	"cleanup_interval",
+	"cache",
+	"cache_size",
+	"cache_root",
+	"index_alt_files",
+	"snapshot",
+	"store_raw",
+	"compress_raw",
+	"min_children",
+	"max_children",
+	"point_epsilon",
+	"query_workers",
+	"sort_uri",
+	"geometry_reader_uri",
+	"geometry_cache_size",
+	"geometry_encoding",
+	// dsn is ignored but accepted for compatibility with URIs used by other spatial database implementations
+	"dsn",
+}
+
+// The length, in degrees, assigned to the zero-length sides of bounding boxes for points and
+// horizontal or vertical lines, since rtreego.NewRect requires that all lengths be positive.
+const minRectLength float64 = 0.0000001
+
+// partBound is the bounding box for a single part (and ring) of a geometry.
+type partBound struct {
+	Part  int
+	Ring  int
+	Bound orb.Bound
+}
+
+// PartFromSpatialId returns the offset of the part of a multi-part geometry encoded in 'sp_id', which is
+// expected to be the Id of a `RTreeSpatialIndex` or a `spatial.PointInPolygonCandidate` produced by this package.
+func PartFromSpatialId(sp_id string) (int, error) {
+
+	parts := strings.Split(sp_id, ":")
+
+	if len(parts) != 3 {
+		return -1, fmt.Errorf("Invalid spatial ID '%s'", sp_id)
+	}
+
+	part, err := strconv.Atoi(parts[1])
+
+	if err != nil {
+		return -1, fmt.Errorf("Invalid part in spatial ID '%s', %w", sp_id, err)
+	}
+
+	return part, nil
+}
+
+func newRectFromBound(bbox orb.Bound) (rtreego.Rect, error) {
+
+	min := bbox.Min
+	max := bbox.Max
+
+	min_x := min[0]
+	min_y := min[1]
+
+	max_x := max[0]
+	max_y := max[1]
+
+	llat := max_y - min_y
+	llon := max_x - min_x
+
+	if llon == 0.0 {
+		min_x = min_x - (minRectLength / 2.0)
+		llon = minRectLength
+	}
+
+	if llat == 0.0 {
+		min_y = min_y - (minRectLength / 2.0)
+		llat = minRectLength
+	}
+
+	pt := rtreego.Point{min_x, min_y}
+	return rtreego.NewRect(pt, []float64{llon, llat})
+}
+
+func boundFromRect(rect rtreego.Rect) orb.Bound {
+
+	min_x := rect.PointCoord(0)
+	min_y := rect.PointCoord(1)
+
+	max_x := min_x + rect.LengthsCoord(0)
+	max_y := min_y + rect.LengthsCoord(1)
+
+	return orb.Bound{
+		Min: orb.Point{min_x, min_y},
+		Max: orb.Point{max_x, max_y},
+	}
+}
+
+// queryWithChannelsFunc is a function that performs a query dispatching results, errors and a completion
+// notice to the channels it is passed.
+type queryWithChannelsFunc func(context.Context, chan spr.StandardPlacesResult, chan error, chan bool)
+
+// geometryTestFunc is a function used to determine whether a candidate geometry satisfies a query.
+type geometryTestFunc func(orb.Geometry) bool
+
 type RTreeResults struct {
 	spr.StandardPlacesResults `json:",omitempty"`
 	Places                    []spr.StandardPlacesResult `json:"places"`
@@ -88,162 +243,388 @@ func NewRTreeSpatialDatabase(ctx context.Context, uri string) (database.SpatialD
 		strict = false
 	}
 
-	expires := 0 * time.Second
-	cleanup := 0 * time.Second
+	if strict {
+
+		for k := range q {
+
+			if !slices.Contains(validURIParameters, k) {
+				return nil, fmt.Errorf("Invalid parameter '%s'", k)
+			}
+		}
+	}
+
+	index_alt_files := false
+
+	str_index_alt := q.Get("index_alt_files")
+
+	if str_index_alt != "" {
+
+		index_alt, err := strconv.ParseBool(str_index_alt)
+
+		if err != nil {
+			return nil, err
+		}
+
+		index_alt_files = index_alt
+	}
+
+	store_raw := false
+	compress_raw := false
 
-	str_exp := q.Get("default_expiration")
-	str_cleanup := q.Get("cleanup_interval")
+	str_store_raw := q.Get("store_raw")
 
-	if str_exp != "" {
+	if str_store_raw != "" {
 
-		int_expires, err := strconv.Atoi(str_exp)
+		v, err := strconv.ParseBool(str_store_raw)
 
 		if err != nil {
 			return nil, err
 		}
 
-		expires = time.Duration(int_expires) * time.Second
+		store_raw = v
 	}
 
-	if str_cleanup != "" {
+	str_compress_raw := q.Get("compress_raw")
 
-		int_cleanup, err := strconv.Atoi(str_cleanup)
+	if str_compress_raw != "" {
+
+		v, err := strconv.ParseBool(str_compress_raw)
 
 		if err != nil {
 			return nil, err
 		}
 
-		cleanup = time.Duration(int_cleanup) * time.Second
+		compress_raw = v
 	}
 
-	index_alt_files := false
+	min_children := defaultMinChildren
+	max_children := defaultMaxChildren
 
-	str_index_alt := q.Get("index_alt_files")
+	str_min := q.Get("min_children")
+	str_max := q.Get("max_children")
 
-	if str_index_alt != "" {
+	if str_min != "" {
 
-		index_alt, err := strconv.ParseBool(str_index_alt)
+		v, err := strconv.Atoi(str_min)
 
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("Invalid min_children parameter, %w", err)
 		}
 
-		index_alt_files = index_alt
+		min_children = v
+	}
+
+	if str_max != "" {
+
+		v, err := strconv.Atoi(str_max)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid max_children parameter, %w", err)
+		}
+
+		max_children = v
 	}
 
-	gc := gocache.New(expires, cleanup)
+	if min_children < 1 {
+		return nil, fmt.Errorf("Invalid min_children parameter, must be greater than zero")
+	}
 
-	rtree := rtreego.NewTree(2, 25, 50)
+	// An overflowing node is split in to two nodes each of which must have at least min_children entries
+
+	if max_children < min_children*2 {
+		return nil, fmt.Errorf("Invalid max_children parameter, must be at least twice min_children (%d)", min_children)
+	}
+
+	point_epsilon := defaultPointEpsilon
+
+	str_epsilon := q.Get("point_epsilon")
+
+	if str_epsilon != "" {
+
+		v, err := strconv.ParseFloat(str_epsilon, 64)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid point_epsilon parameter, %w", err)
+		}
+
+		if v <= 0.0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("Invalid point_epsilon parameter, must be a positive number")
+		}
+
+		point_epsilon = v
+	}
+
+	query_workers := runtime.NumCPU()
+
+	str_workers := q.Get("query_workers")
+
+	if str_workers != "" {
+
+		v, err := strconv.Atoi(str_workers)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid query_workers parameter, %w", err)
+		}
+
+		if v < 1 {
+			return nil, fmt.Errorf("Invalid query_workers parameter, must be greater than zero")
+		}
+
+		query_workers = v
+	}
+
+	sorters, err := newResultSorters(ctx, q["sort_uri"])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid sort_uri parameter, %w", err)
+	}
+
+	var geometry_reader reader.Reader
+	geometry_cache_size := 0
+
+	geometry_reader_uri := q.Get("geometry_reader_uri")
+
+	if geometry_reader_uri != "" {
+
+		rdr, err := reader.NewReader(ctx, geometry_reader_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create geometry reader, %w", err)
+		}
+
+		geometry_reader = rdr
+
+		str_size := q.Get("geometry_cache_size")
+
+		if str_size != "" {
+
+			size, err := strconv.Atoi(str_size)
+
+			if err != nil {
+				return nil, fmt.Errorf("Invalid geometry_cache_size parameter, %w", err)
+			}
+
+			if size < 0 {
+				return nil, fmt.Errorf("Invalid geometry_cache_size parameter, must not be negative")
+			}
+
+			geometry_cache_size = size
+		}
+	}
+
+	geometry_encoding := q.Get("geometry_encoding")
+
+	if geometry_encoding != "" && !isValidGeometryEncoding(geometry_encoding) {
+		return nil, fmt.Errorf("Invalid geometry_encoding parameter '%s'", geometry_encoding)
+	}
+
+	cache, err := newFeatureCache(ctx, q)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create cache, %w", err)
+	}
+
+	current := new(atomic.Pointer[generation])
+	current.Store(newGeneration(cache, newGeometryCache(geometry_cache_size), min_children, max_children))
 
 	mu := new(sync.RWMutex)
+	write_mu := new(sync.Mutex)
+	rebuild_mu := new(sync.Mutex)
 
 	db := &RTreeSpatialDatabase{
-		rtree:           rtree,
-		index_alt_files: index_alt_files,
-		gocache:         gc,
-		strict:          strict,
-		mu:              mu,
+		current:             current,
+		cache_params:        q,
+		index_alt_files:     index_alt_files,
+		strict:              strict,
+		store_raw:           store_raw,
+		compress_raw:        compress_raw,
+		min_children:        min_children,
+		max_children:        max_children,
+		point_epsilon:       point_epsilon,
+		query_workers:       query_workers,
+		sorters:             sorters,
+		geometry_reader:     geometry_reader,
+		geometry_cache_size: geometry_cache_size,
+		geometry_encoding:   geometry_encoding,
+		mu:                  mu,
+		write_mu:            write_mu,
+		rebuild_mu:          rebuild_mu,
+	}
+
+	snapshot_path := q.Get("snapshot")
+
+	if snapshot_path != "" {
+
+		err := db.ReadSnapshotFile(ctx, snapshot_path)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read snapshot %s, %w", snapshot_path, err)
+		}
 	}
 
 	return db, nil
 }
 
 func (r *RTreeSpatialDatabase) Disconnect(ctx context.Context) error {
-	return nil
+
+	r.write_mu.Lock()
+	defer r.write_mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.current.Load().close(ctx)
+}
+
+func (r *RTreeSpatialDatabase) Close(ctx context.Context) error {
+	return r.Disconnect(ctx)
 }
 
 func (r *RTreeSpatialDatabase) IndexFeature(ctx context.Context, body []byte) error {
 
+	rec, err := r.newIndexRecord(ctx, body)
+
+	if err != nil {
+		return err
+	}
+
+	if rec == nil {
+		return nil
+	}
+
+	// Replace any existing entries for this feature (and alt label) so that
+	// re-indexing a feature does not leave stale bounds in the rtree
+
+	op := func(ctx context.Context, g *generation) error {
+		return g.replaceRecord(ctx, rec)
+	}
+
+	return r.applyWrite(ctx, op)
+}
+
+// indexRecord is the cache item and rtree entries derived from a single feature.
+type indexRecord struct {
+	CacheKey  string
+	CacheItem *RTreeCache
+	FeatureId string
+	AltLabel  string
+	Entries   []*RTreeSpatialIndex
+}
+
+// newIndexRecord derives the cache item and rtree entries for 'body' without modifying the database. It
+// returns nil (and no error) if 'body' should not be indexed.
+func (r *RTreeSpatialDatabase) newIndexRecord(ctx context.Context, body []byte) (*indexRecord, error) {
+
 	is_alt := alt.IsAlt(body)
 	alt_label, _ := properties.AltLabel(body)
 
 	if is_alt && !r.index_alt_files {
-		return nil
+		return nil, nil
 	}
 
 	if is_alt && alt_label == "" {
-		return fmt.Errorf("Invalid alt label")
+		return nil, fmt.Errorf("Invalid alt label")
 	}
 
-	err := r.setCache(ctx, body)
+	cache_key, cache_item, err := r.newCacheItem(ctx, body)
 
 	if err != nil {
-		return fmt.Errorf("Failed to cache feature, %w", err)
+		return nil, fmt.Errorf("Failed to derive cache item for feature, %w", err)
 	}
 
 	feature_id, err := properties.Id(body)
 
 	if err != nil {
-		return fmt.Errorf("Failed to derive ID, %w", err)
+		return nil, fmt.Errorf("Failed to derive ID, %w", err)
 	}
 
 	str_id := strconv.FormatInt(feature_id, 10)
 
 	// START OF put me in go-whosonfirst-feature/geometry
 
-	geojson_geom, err := geometry.Geometry(body)
+	orb_geom := cache_item.Geometry.Geometry()
 
-	if err != nil {
-		return fmt.Errorf("Failed to derive geometry, %w", err)
-	}
+	bounds := make([]*partBound, 0)
+
+	// addBounds appends the bounds for 'points', split in two if they cross the antimeridian
 
-	orb_geom := geojson_geom.Geometry()
+	addBounds := func(part int, ring int, points []orb.Point) {
+
+		if !crossesAntimeridian(points) {
+			bounds = append(bounds, &partBound{Part: part, Ring: ring, Bound: orb.MultiPoint(points).Bound()})
+			return
+		}
 
-	bounds := make([]orb.Bound, 0)
+		for _, b := range antimeridianBounds(points) {
+			bounds = append(bounds, &partBound{Part: part, Ring: ring, Bound: b})
+		}
+	}
 
 	switch orb_geom.GeoJSONType() {
 
 	case "MultiPolygon":
 
-		for _, poly := range orb_geom.(orb.MultiPolygon) {
+		// Only the exterior ring of each polygon is indexed since interior rings (holes) are, by
+		// definition, contained by it
+
+		for part, poly := range orb_geom.(orb.MultiPolygon) {
 
-			for _, ring := range poly {
-				bounds = append(bounds, ring.Bound())
+			if len(poly) == 0 {
+				continue
 			}
+
+			addBounds(part, 0, poly[0])
 		}
 
 	case "Polygon":
 
-		for _, ring := range orb_geom.(orb.Polygon) {
-			bounds = append(bounds, ring.Bound())
+		poly := orb_geom.(orb.Polygon)
+
+		if len(poly) > 0 {
+			addBounds(0, 0, poly[0])
 		}
-	default:
-		bounds = append(bounds, orb_geom.Bound())
-	}
 
-	// END OF put me in go-whosonfirst-feature/geometry
+	case "MultiLineString":
+
+		for part, line := range orb_geom.(orb.MultiLineString) {
+			addBounds(part, 0, line)
+		}
 
-	for i, bbox := range bounds {
+	case "LineString":
+		addBounds(0, 0, orb_geom.(orb.LineString))
 
-		sp_id, err := spatial.SpatialIdWithFeature(body, i)
+	case "MultiPoint":
 
-		if err != nil {
-			return fmt.Errorf("Failed to derive spatial ID, %v", err)
+		for part, pt := range orb_geom.(orb.MultiPoint) {
+			bounds = append(bounds, &partBound{Part: part, Bound: pt.Bound()})
 		}
 
-		min := bbox.Min
-		max := bbox.Max
+	default:
+		bounds = append(bounds, &partBound{Part: 0, Bound: orb_geom.Bound()})
+	}
+
+	// END OF put me in go-whosonfirst-feature/geometry
 
-		min_x := min[0]
-		min_y := min[1]
+	entries := make([]*RTreeSpatialIndex, 0)
 
-		max_x := max[0]
-		max_y := max[1]
+	for _, pb := range bounds {
 
-		llat := max_y - min_y
-		llon := max_x - min_x
+		sp_id, err := spatial.SpatialIdWithFeature(body, pb.Part, pb.Ring)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive spatial ID, %v", err)
+		}
 
-		pt := rtreego.Point{min_x, min_y}
-		rect, err := rtreego.NewRect(pt, []float64{llon, llat})
+		rect, err := newRectFromBound(pb.Bound)
 
 		if err != nil {
 
 			if r.strict {
-				return fmt.Errorf("Failed to derive rtree bounds, %w", err)
+				return nil, fmt.Errorf("Failed to derive rtree bounds, %w", err)
 			}
 
 			slog.Error("Failed to index feature", "id", sp_id, "error", err)
-			return nil
+			return nil, nil
 		}
 
 		sp := &RTreeSpatialIndex{
@@ -252,82 +633,82 @@ func (r *RTreeSpatialDatabase) IndexFeature(ctx context.Context, body []byte) er
 			FeatureId: str_id,
 			IsAlt:     is_alt,
 			AltLabel:  alt_label,
+			Part:      pb.Part,
 		}
 
-		r.mu.Lock()
-		r.rtree.Insert(sp)
-
-		r.mu.Unlock()
+		entries = append(entries, sp)
 	}
 
-	return nil
-}
+	// In lazy geometry mode only the bounds derived above are kept in memory
+
+	if r.geometry_reader != nil {
+		cache_item.Geometry = nil
+	} else if r.geometry_encoding != "" {
 
-/*
+		enc_geom, err := encodeGeometry(orb_geom, r.geometry_encoding)
 
-TO DO: figure out suitable comparitor
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode geometry, %w", err)
+		}
 
-/ DeleteWithComparator removes an object from the tree using a custom
-// comparator for evaluating equalness. This is useful when you want to remove
-// an object from a tree but don't have a pointer to the original object
-// anymore.
-func (tree *Rtree) DeleteWithComparator(obj Spatial, cmp Comparator) bool {
-	n := tree.findLeaf(tree.root, obj, cmp)
+		cache_item.EncodedGeometry = enc_geom
+		cache_item.GeometryEncoding = r.geometry_encoding
+		cache_item.Geometry = nil
+	}
 
-// Comparator compares two spatials and returns whether they are equal.
-type Comparator func(obj1, obj2 Spatial) (equal bool)
+	rec := &indexRecord{
+		CacheKey:  cache_key,
+		CacheItem: cache_item,
+		FeatureId: str_id,
+		AltLabel:  alt_label,
+		Entries:   entries,
+	}
 
-func defaultComparator(obj1, obj2 Spatial) bool {
-	return obj1 == obj2
+	return rec, nil
 }
 
-*/
-
 func (r *RTreeSpatialDatabase) RemoveFeature(ctx context.Context, id string) error {
 
-	obj := &RTreeSpatialIndex{
-		Rect: nil,
-		Id:   id,
+	op := func(ctx context.Context, g *generation) error {
+		return g.removeFeature(ctx, id)
 	}
 
-	comparator := func(obj1, obj2 rtreego.Spatial) bool {
-
-		// 2021/10/12 11:17:11 COMPARE 1: '101737491#:0' 2: '101737491'
-		// log.Printf("COMPARE 1: '%v' 2: '%v'\n", obj1.(*RTreeSpatialIndex).Id, obj2.(*RTreeSpatialIndex).Id)
-
-		obj1_id := obj1.(*RTreeSpatialIndex).Id
-		obj2_id := obj2.(*RTreeSpatialIndex).Id
-
-		return strings.HasPrefix(obj1_id, obj2_id)
-	}
+	return r.applyWrite(ctx, op)
+}
 
-	ok := r.rtree.DeleteWithComparator(obj, comparator)
+func (r *RTreeSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
 
-	if !ok {
-		return fmt.Errorf("Failed to remove %s from rtree", id)
+	query_func := func(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool) {
+		r.PointInPolygonWithChannels(ctx, rsp_ch, err_ch, done_ch, coord, filters...)
 	}
 
-	return nil
+	return r.collectResults(ctx, query_func)
 }
 
-func (r *RTreeSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Point, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+// collectResults invokes 'query_func' (which is expected to be one of the "WithChannels" query methods) and
+// gathers the results it emits, sorted using the database's sort URIs, in to a `spr.StandardPlacesResults`
+// instance. If 'ctx' is cancelled, or the query fails, then the query is cancelled and an error is returned.
+func (r *RTreeSpatialDatabase) collectResults(ctx context.Context, query_func queryWithChannelsFunc) (spr.StandardPlacesResults, error) {
+
+	// Cancelling the query when this function returns (for whatever reason) stops any goroutines
+	// still testing candidates
 
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	rsp_ch := make(chan spr.StandardPlacesResult)
-	err_ch := make(chan error)
-	done_ch := make(chan bool)
+	err_ch := make(chan error, 1)
+	done_ch := make(chan bool, 1)
 
 	results := make([]spr.StandardPlacesResult, 0)
 	working := true
 
-	go r.PointInPolygonWithChannels(ctx, rsp_ch, err_ch, done_ch, coord, filters...)
+	go query_func(ctx, rsp_ch, err_ch, done_ch)
 
-	for {
+	for working {
 		select {
 		case <-ctx.Done():
-			return nil, nil
+			return nil, ctx.Err()
 		case <-done_ch:
 			working = false
 		case rsp := <-rsp_ch:
@@ -335,10 +716,21 @@ func (r *RTreeSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Po
 		case err := <-err_ch:
 			return nil, err
 		}
+	}
 
-		if !working {
-			break
-		}
+	// An error may have been dispatched immediately before done_ch was notified
+
+	select {
+	case err := <-err_ch:
+		return nil, err
+	default:
+		// pass
+	}
+
+	results, err := sortResults(ctx, results, r.sorters)
+
+	if err != nil {
+		return nil, err
 	}
 
 	spr_results := &RTreeResults{
@@ -348,20 +740,57 @@ func (r *RTreeSpatialDatabase) PointInPolygon(ctx context.Context, coord *orb.Po
 	return spr_results, nil
 }
 
+// sendError dispatches 'err' to 'err_ch' unless 'ctx' is cancelled first, in which case whoever is reading from
+// 'err_ch' may have stopped doing so.
+func sendError(ctx context.Context, err_ch chan error, err error) {
+
+	select {
+	case <-ctx.Done():
+	case err_ch <- err:
+	}
+}
+
+// sendDone notifies 'done_ch' unless 'ctx' is cancelled first, in which case whoever is reading from 'done_ch'
+// may have stopped doing so.
+func sendDone(ctx context.Context, done_ch chan bool) {
+
+	select {
+	case <-ctx.Done():
+	case done_ch <- true:
+	}
+}
+
 func (r *RTreeSpatialDatabase) PointInPolygonWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...spatial.Filter) {
 
-	defer func() {
-		done_ch <- true
-	}()
+	defer sendDone(ctx, done_ch)
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
 
-	rows, err := r.getIntersectsByCoord(coord)
+	rows, err := r.getIntersectsByCoord(g, coord)
 
 	if err != nil {
-		err_ch <- err
+		sendError(ctx, err_ch, err)
 		return
 	}
 
-	r.inflateResultsWithChannels(ctx, rsp_ch, err_ch, rows, coord, filters...)
+	contains_func := func(orb_geom orb.Geometry) bool {
+
+		switch orb_geom.GeoJSONType() {
+		case "Polygon":
+			return planar.PolygonContains(orb_geom.(orb.Polygon), *coord)
+		case "MultiPolygon":
+			return planar.MultiPolygonContains(orb_geom.(orb.MultiPolygon), *coord)
+		case "Point", "MultiPoint", "LineString", "MultiLineString":
+			// Points and lines have no area so they can never contain a coordinate
+			return false
+		default:
+			slog.Debug("Geometry has unsupported geometry", "type", orb_geom.GeoJSONType())
+			return false
+		}
+	}
+
+	r.inflateResultsWithChannels(ctx, g, rsp_ch, err_ch, rows, contains_func, true, filters...)
 	return
 }
 
@@ -371,18 +800,18 @@ func (r *RTreeSpatialDatabase) PointInPolygonCandidates(ctx context.Context, coo
 	defer cancel()
 
 	rsp_ch := make(chan *spatial.PointInPolygonCandidate)
-	err_ch := make(chan error)
-	done_ch := make(chan bool)
+	err_ch := make(chan error, 1)
+	done_ch := make(chan bool, 1)
 
 	candidates := make([]*spatial.PointInPolygonCandidate, 0)
 	working := true
 
 	go r.PointInPolygonCandidatesWithChannels(ctx, rsp_ch, err_ch, done_ch, coord, filters...)
 
-	for {
+	for working {
 		select {
 		case <-ctx.Done():
-			return nil, nil
+			return nil, ctx.Err()
 		case <-done_ch:
 			working = false
 		case rsp := <-rsp_ch:
@@ -390,25 +819,35 @@ func (r *RTreeSpatialDatabase) PointInPolygonCandidates(ctx context.Context, coo
 		case err := <-err_ch:
 			return nil, err
 		}
+	}
 
-		if !working {
-			break
-		}
+	select {
+	case err := <-err_ch:
+		return nil, err
+	default:
+		// pass
 	}
 
+	// Candidates are emitted in the order the rtree returns them which depends on the order features were indexed
+
+	slices.SortFunc(candidates, func(a *spatial.PointInPolygonCandidate, b *spatial.PointInPolygonCandidate) int {
+		return strings.Compare(a.Id, b.Id)
+	})
+
 	return candidates, nil
 }
 
 func (r *RTreeSpatialDatabase) PointInPolygonCandidatesWithChannels(ctx context.Context, rsp_ch chan *spatial.PointInPolygonCandidate, err_ch chan error, done_ch chan bool, coord *orb.Point, filters ...spatial.Filter) {
 
-	defer func() {
-		done_ch <- true
-	}()
+	defer sendDone(ctx, done_ch)
 
-	intersects, err := r.getIntersectsByCoord(coord)
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	intersects, err := r.getIntersectsByCoord(g, coord)
 
 	if err != nil {
-		err_ch <- err
+		sendError(ctx, err_ch, err)
 		return
 	}
 
@@ -416,201 +855,381 @@ func (r *RTreeSpatialDatabase) PointInPolygonCandidatesWithChannels(ctx context.
 
 		sp := raw.(*RTreeSpatialIndex)
 
-		// bounds := sp.Bounds()
+		// The part of a multi-part geometry that produced the candidate is encoded
+		// in its Id and can be retrieved using the PartFromSpatialId method
 
 		c := &spatial.PointInPolygonCandidate{
 			Id:        sp.Id,
 			FeatureId: sp.FeatureId,
+			IsAlt:     sp.IsAlt,
 			AltLabel:  sp.AltLabel,
-			// FIX ME
-			// Bounds:   bounds,
+			Bounds:    boundFromRect(*sp.Rect),
 		}
 
-		rsp_ch <- c
+		select {
+		case <-ctx.Done():
+			return
+		case rsp_ch <- c:
+			// pass
+		}
 	}
 
 	return
 }
 
-func (r *RTreeSpatialDatabase) getIntersectsByCoord(coord *orb.Point) ([]rtreego.Spatial, error) {
+func (r *RTreeSpatialDatabase) getIntersectsByCoord(g *generation, coord *orb.Point) ([]rtreego.Spatial, error) {
 
 	lat := coord.Y()
 	lon := coord.X()
 
 	pt := rtreego.Point{lon, lat}
-	rect, err := rtreego.NewRect(pt, []float64{0.0001, 0.0001}) // how small can I make this?
+	rect, err := rtreego.NewRect(pt, []float64{r.point_epsilon, r.point_epsilon})
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to derive rtree bounds, %w", err)
 	}
 
-	return r.getIntersectsByRect(&rect)
+	return r.getIntersectsByRect(g, &rect)
+}
+
+// getIntersectsByBound returns the rtree entries which intersect 'b' which may cross the antimeridian.
+func (r *RTreeSpatialDatabase) getIntersectsByBound(g *generation, b orb.Bound) ([]rtreego.Spatial, error) {
+
+	results := make([]rtreego.Spatial, 0)
+
+	for _, sb := range splitBound(b) {
+
+		rect, err := newRectFromBound(sb)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive rtree bounds, %w", err)
+		}
+
+		rows, err := r.getIntersectsByRect(g, &rect)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, rows...)
+	}
+
+	return results, nil
 }
 
-func (r *RTreeSpatialDatabase) getIntersectsByRect(rect *rtreego.Rect) ([]rtreego.Spatial, error) {
+func (r *RTreeSpatialDatabase) getIntersectsByRect(g *generation, rect *rtreego.Rect) ([]rtreego.Spatial, error) {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
 
-	results := r.rtree.SearchIntersect(*rect)
+	results := g.rtree.SearchIntersect(*rect)
 	return results, nil
 }
 
-func (r *RTreeSpatialDatabase) inflateResultsWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, possible []rtreego.Spatial, c *orb.Point, filters ...spatial.Filter) {
+// candidateCacheItem returns the cache item for 'sp', a candidate returned by a search of 'g', or nil if
+// the candidate should be skipped because it was removed (or replaced) after the search.
+func (r *RTreeSpatialDatabase) candidateCacheItem(ctx context.Context, g *generation, sp *RTreeSpatialIndex) (*RTreeCache, error) {
+
+	cache_item, err := g.retrieveCache(ctx, sp)
+
+	if err == nil {
+		return cache_item, nil
+	}
+
+	if !r.isIndexed(g, sp) {
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("Failed to retrieve cache item for %s, %w", sp.Id, err)
+}
+
+// isIndexed returns a boolean value indicating whether 'sp' is still in 'g'. Since queries do not hold
+// a lock while results are inflated this is used to distinguish entries that were removed (or replaced) after
+// they were returned by a search from entries whose cache items are actually missing.
+func (r *RTreeSpatialDatabase) isIndexed(g *generation, sp *RTreeSpatialIndex) bool {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return g.isIndexed(sp)
+}
+
+// inflateResultsWithChannels dispatches the SPR for each unique feature in 'possible' that matches 'filters' and
+// 'test_func' to 'rsp_ch' and any errors retrieving their cache items or geometries to 'err_ch'. If 'test_parts'
+// is true then 'test_func' is only applied to the part of a multi-part geometry whose bounds produced the candidate
+// (and a feature matches if any of its parts do) otherwise it is applied to the feature's entire geometry.
+// Candidates are tested by a pool of (at most `query_workers`) goroutines or, if there are only a few of them,
+// sequentially.
+func (r *RTreeSpatialDatabase) inflateResultsWithChannels(ctx context.Context, g *generation, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, possible []rtreego.Spatial, test_func geometryTestFunc, test_parts bool, filters ...spatial.Filter) {
+
+	test_func = antimeridianTestFunc(test_func)
 
 	seen := make(map[string]bool)
 
-	mu := new(sync.RWMutex)
+	mu := new(sync.Mutex)
 	wg := new(sync.WaitGroup)
 
-	for _, row := range possible {
+	// claim returns true if 'cache_key' has not been seen before, marking it as seen
 
-		sp := row.(*RTreeSpatialIndex)
-		wg.Add(1)
+	claim := func(cache_key string) bool {
 
-		go func(sp *RTreeSpatialIndex) {
+		mu.Lock()
+		defer mu.Unlock()
 
-			sp_id := sp.Id
-			feature_id := sp.FeatureId
+		if seen[cache_key] {
+			return false
+		}
 
-			defer wg.Done()
+		seen[cache_key] = true
+		return true
+	}
 
-			select {
-			case <-ctx.Done():
-				return
-			default:
-				// pass
-			}
+	// seenAlready returns true if 'cache_key' has already been seen
 
-			mu.RLock()
-			_, ok := seen[feature_id]
-			mu.RUnlock()
+	seenAlready := func(cache_key string) bool {
 
-			if ok {
-				return
-			}
+		mu.Lock()
+		defer mu.Unlock()
 
-			mu.Lock()
-			seen[feature_id] = true
-			mu.Unlock()
+		return seen[cache_key]
+	}
 
-			cache_item, err := r.retrieveCache(ctx, sp)
+	// inflate tests a single candidate and dispatches its SPR if it matches
 
-			if err != nil {
-				slog.Error("Failed to retrieve cache item", "id", sp_id, "error", err)
+	inflate := func(sp *RTreeSpatialIndex) {
+
+		sp_id := sp.Id
+		cache_key := cacheKey(sp.FeatureId, sp.AltLabel)
+
+		select {
+		case <-ctx.Done():
+			return
+		default:
+			// pass
+		}
+
+		// When testing individual parts a feature is only marked as seen once one of
+		// its parts has matched since the other parts still need to be tested
+
+		if test_parts {
+
+			if seenAlready(cache_key) {
 				return
 			}
 
-			s := cache_item.SPR
+		} else if !claim(cache_key) {
+			return
+		}
+
+		cache_item, err := r.candidateCacheItem(ctx, g, sp)
 
-			for _, f := range filters {
+		if err != nil {
+			sendError(ctx, err_ch, err)
+			return
+		}
 
-				err = filter.FilterSPR(f, s)
+		if cache_item == nil {
+			return
+		}
 
-				if err != nil {
-					return
-				}
-			}
+		s := cache_item.SPR
 
-			geom := cache_item.Geometry
+		if !matchesFilters(s, filters...) {
+			return
+		}
 
-			orb_geom := geom.Geometry()
-			geom_type := orb_geom.GeoJSONType()
+		orb_geom, err := r.retrieveGeometry(ctx, g, sp, cache_item)
 
-			contains := false
+		if err != nil {
+			sendError(ctx, err_ch, fmt.Errorf("Failed to retrieve geometry for %s, %w", sp_id, err))
+			return
+		}
 
-			switch geom_type {
-			case "Polygon":
-				contains = planar.PolygonContains(orb_geom.(orb.Polygon), *c)
-			case "MultiPolygon":
-				contains = planar.MultiPolygonContains(orb_geom.(orb.MultiPolygon), *c)
-			default:
-				slog.Debug("Geometry has unsupported geometry", "type", geom.Type)
-			}
+		if test_parts {
+			orb_geom = geometryPart(orb_geom, sp.Part)
+		}
 
-			if !contains {
-				return
+		if !test_func(orb_geom) {
+			return
+		}
+
+		if test_parts && !claim(cache_key) {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+		case rsp_ch <- s:
+		}
+	}
+
+	// Testing a handful of candidates is faster than starting goroutines to test them
+
+	workers := min(r.query_workers, len(possible))
+
+	if workers <= 1 || len(possible) <= sequentialCandidates {
+
+		for _, row := range possible {
+			inflate(row.(*RTreeSpatialIndex))
+		}
+
+		return
+	}
+
+	queue := make(chan *RTreeSpatialIndex)
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for sp := range queue {
+				inflate(sp)
 			}
+		}()
+	}
+
+feed:
+	for _, row := range possible {
 
-			rsp_ch <- s
-		}(sp)
+		select {
+		case <-ctx.Done():
+			break feed
+		case queue <- row.(*RTreeSpatialIndex):
+			// pass
+		}
 	}
 
+	close(queue)
 	wg.Wait()
 }
 
-func (r *RTreeSpatialDatabase) setCache(ctx context.Context, body []byte) error {
+func (r *RTreeSpatialDatabase) newCacheItem(ctx context.Context, body []byte) (string, *RTreeCache, error) {
+
+	var s spr.StandardPlacesResult
+	var err error
 
-	s, err := spr.WhosOnFirstSPR(body)
+	if alt.IsAlt(body) {
+		s, err = spr.WhosOnFirstAltSPR(body)
+	} else {
+		s, err = spr.WhosOnFirstSPR(body)
+	}
 
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
 	geom, err := geometry.Geometry(body)
 
 	if err != nil {
-		return fmt.Errorf("Failed to derive geometry for feature, %w", err)
+		return "", nil, fmt.Errorf("Failed to derive geometry for feature, %w", err)
 	}
 
 	alt_label, err := properties.AltLabel(body)
 
 	if err != nil {
-		return fmt.Errorf("Failed to derive alt label, %w", err)
+		return "", nil, fmt.Errorf("Failed to derive alt label, %w", err)
 	}
 
 	feature_id, err := properties.Id(body)
 
 	if err != nil {
-		return fmt.Errorf("Failed to derive feature ID, %w", err)
+		return "", nil, fmt.Errorf("Failed to derive feature ID, %w", err)
 	}
 
-	cache_key := fmt.Sprintf("%d:%s", feature_id, alt_label)
+	str_id := strconv.FormatInt(feature_id, 10)
+	cache_key := cacheKey(str_id, alt_label)
 
 	cache_item := &RTreeCache{
 		Geometry: geom,
 		SPR:      s,
 	}
 
-	r.gocache.Set(cache_key, cache_item, -1)
-	return nil
-}
+	if r.store_raw {
+
+		var raw_body []byte
 
-func (r *RTreeSpatialDatabase) retrieveCache(ctx context.Context, sp *RTreeSpatialIndex) (*RTreeCache, error) {
+		if r.compress_raw {
 
-	cache_key := fmt.Sprintf("%s:%s", sp.FeatureId, sp.AltLabel)
+			raw_body, err = compressBody(body)
 
-	cache_item, ok := r.gocache.Get(cache_key)
+			if err != nil {
+				return "", nil, fmt.Errorf("Failed to compress feature body, %w", err)
+			}
+		} else {
+			// Make a copy since callers are free to reuse 'body'
+			raw_body = bytes.Clone(body)
+		}
 
-	if !ok {
-		return nil, fmt.Errorf("Invalid cache ID '%s'", cache_key)
+		cache_item.Body = raw_body
+		cache_item.BodyCompressed = r.compress_raw
 	}
 
-	return cache_item.(*RTreeCache), nil
+	return cache_key, cache_item, nil
+}
+
+func cacheKey(feature_id string, alt_label string) string {
+	return fmt.Sprintf("%s:%s", feature_id, alt_label)
 }
 
 // whosonfirst/go-reader interface
 
 func (r *RTreeSpatialDatabase) Read(ctx context.Context, str_uri string) (io.ReadSeekCloser, error) {
 
-	id, _, err := uri.ParseURI(str_uri)
+	id, uri_args, err := uri.ParseURI(str_uri)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to parse URI %s, %w", str_uri, err)
 	}
 
-	// TO DO : ALT STUFF HERE
+	alt_label := ""
+
+	if uri_args.IsAlternate {
+
+		if !r.index_alt_files {
+			return nil, fmt.Errorf("Failed to read %s, alternate geometries are not indexed", str_uri)
+		}
+
+		label, err := uri_args.AltGeom.String()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive alt label for %s, %w", str_uri, err)
+		}
+
+		alt_label = label
+	}
 
 	str_id := strconv.FormatInt(id, 10)
 
 	sp := &RTreeSpatialIndex{
 		FeatureId: str_id,
-		AltLabel:  "",
+		AltLabel:  alt_label,
 	}
 
-	cache_item, err := r.retrieveCache(ctx, sp)
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	cache_item, err := g.retrieveCache(ctx, sp)
 
 	if err != nil {
 		return nil, fmt.Errorf("Failed to retrieve cache, %w", err)
 	}
 
+	if cache_item.Body != nil {
+
+		body, err := cache_item.RawBody()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to derive body for %s, %w", str_uri, err)
+		}
+
+		br := bytes.NewReader(body)
+		return ioutil.NewReadSeekCloser(br)
+	}
+
 	// START OF this is dumb
 
 	enc_spr, err := json.Marshal(cache_item.SPR)
@@ -629,7 +1248,16 @@ func (r *RTreeSpatialDatabase) Read(ctx context.Context, str_uri string) (io.Rea
 
 	// END OF this is dumb
 
-	orb_geom := cache_item.Geometry.Geometry()
+	if alt_label != "" {
+		props["src:alt_label"] = alt_label
+	}
+
+	orb_geom, err := r.retrieveGeometry(ctx, g, sp, cache_item)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to retrieve geometry for %s, %w", str_uri, err)
+	}
+
 	f := geojson.NewFeature(orb_geom)
 
 	if err != nil {
@@ -654,8 +1282,23 @@ func (r *RTreeSpatialDatabase) ReaderURI(ctx context.Context, str_uri string) st
 
 // whosonfirst/go-writer interface
 
+// Write indexes the GeoJSON Feature read from 'fh', replacing any existing entries for the same ID (and alt
+// label), and returns the number of bytes read. 'key' is ignored since the record's ID is derived from its body.
 func (r *RTreeSpatialDatabase) Write(ctx context.Context, key string, fh io.ReadSeeker) (int64, error) {
-	return 0, fmt.Errorf("Not implemented")
+
+	body, err := io.ReadAll(fh)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to read %s, %w", key, err)
+	}
+
+	err = r.IndexFeature(ctx, body)
+
+	if err != nil {
+		return 0, fmt.Errorf("Failed to index %s, %w", key, err)
+	}
+
+	return int64(len(body)), nil
 }
 
 func (r *RTreeSpatialDatabase) WriterURI(ctx context.Context, str_uri string) string {
@@ -1,18 +1,29 @@
 package rtree
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net/url"
 	"os"
+	"runtime"
+	"slices"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
 	"github.com/whosonfirst/go-whosonfirst-spatial-rtree/fixtures"
 	"github.com/whosonfirst/go-whosonfirst-spatial-rtree/fixtures/microhoods"
 	"github.com/whosonfirst/go-whosonfirst-spatial/database"
 	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
 	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
 )
 
 type Criteria struct {
@@ -89,14 +100,8 @@ func TestSpatialDatabase(t *testing.T) {
 	}
 }
 
-// This is known to fail until we keep a local lookup table of all the bounding boxes associated
-// with a feature is created. The way we're doing things in database.RemoveFeature using a comparator
-// doesn't actually work...
-
 func TestSpatialDatabaseRemoveFeature(t *testing.T) {
 
-	t.Skip()
-
 	ctx := context.Background()
 
 	database_uri := "rtree://"
@@ -309,3 +314,1006 @@ func TestSpatialDatabaseWithFeatureCollection(t *testing.T) {
 		}
 	}
 }
+
+func TestSpatialDatabaseReindexFeature(t *testing.T) {
+
+	ctx := context.Background()
+
+	database_uri := "rtree://"
+
+	db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	id := 1108712253
+	lat := 42.376015
+	lon := -71.120168
+
+	test_data := fmt.Sprintf("fixtures/microhoods/%d.geojson", id)
+
+	body, err := os.ReadFile(test_data)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", test_data, err)
+	}
+
+	for i := 0; i < 2; i++ {
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index %s, %v", test_data, err)
+		}
+	}
+
+	count_entries := len(db.(*RTreeSpatialDatabase).current.Load().lookup[strconv.Itoa(id)])
+
+	if count_entries != 1 {
+		t.Fatalf("Expected 1 rtree entry but got %d", count_entries)
+	}
+
+	c, err := geo.NewCoordinate(lon, lat)
+
+	if err != nil {
+		t.Fatalf("Failed to create new coordinate, %v", err)
+	}
+
+	spr, err := db.PointInPolygon(ctx, c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	count := len(spr.Results())
+
+	if count != 1 {
+		t.Fatalf("Expected 1 result but got %d", count)
+	}
+
+	// Now move the feature somewhere else and make sure the old bounds are gone
+
+	moved_body := featureWithGeometry(t, body, orb.Polygon{
+		orb.Ring{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0, 0}},
+	})
+
+	err = db.IndexFeature(ctx, moved_body)
+
+	if err != nil {
+		t.Fatalf("Failed to re-index %s, %v", test_data, err)
+	}
+
+	spr, err = db.PointInPolygon(ctx, c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	count = len(spr.Results())
+
+	if count != 0 {
+		t.Fatalf("Expected 0 results for original location but got %d", count)
+	}
+
+	moved_c, err := geo.NewCoordinate(0.5, 0.5)
+
+	if err != nil {
+		t.Fatalf("Failed to create new coordinate, %v", err)
+	}
+
+	spr, err = db.PointInPolygon(ctx, moved_c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	count = len(spr.Results())
+
+	if count != 1 {
+		t.Fatalf("Expected 1 result for new location but got %d", count)
+	}
+}
+
+// featureWithGeometry returns a copy of the feature in 'body' whose geometry has been replaced by 'orb_geom'.
+func featureWithGeometry(t testing.TB, body []byte, orb_geom orb.Geometry) []byte {
+
+	f, err := geojson.UnmarshalFeature(body)
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal feature, %v", err)
+	}
+
+	f.Geometry = orb_geom
+
+	new_body, err := f.MarshalJSON()
+
+	if err != nil {
+		t.Fatalf("Failed to marshal feature, %v", err)
+	}
+
+	return new_body
+}
+
+// failingCache is a `featureCache` whose Set method fails if 'fail' is true.
+type failingCache struct {
+	featureCache
+	fail bool
+}
+
+func (c *failingCache) Set(ctx context.Context, key string, cache_item *RTreeCache) error {
+
+	if c.fail {
+		return fmt.Errorf("Failed to store %s", key)
+	}
+
+	return c.featureCache.Set(ctx, key, cache_item)
+}
+
+func TestSpatialDatabaseReindexFeatureCacheFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	g := db.(*RTreeSpatialDatabase).current.Load()
+
+	c := &failingCache{featureCache: g.cache}
+	g.cache = c
+
+	b1 := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}
+	b2 := orb.Bound{Min: orb.Point{5, 5}, Max: orb.Point{6, 6}}
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, b1.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	// If the new cache item can not be stored the existing entries and cache item should be left alone
+
+	c.fail = true
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, b2.ToPolygon()))
+
+	if err == nil {
+		t.Fatalf("Expected re-indexing feature to fail")
+	}
+
+	pip_tests := map[orb.Point][]string{
+		{0.5, 0.5}: {"1"},
+		{5.5, 5.5}: {},
+	}
+
+	for c, expected := range pip_tests {
+
+		rsp, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertIds(t, rsp.Results(), expected)
+	}
+}
+
+// newTestFeature returns a copy of the Old Cambridge microhood fixture whose ID and geometry have been
+// replaced by 'id' and 'orb_geom'.
+func newTestFeature(t testing.TB, id int64, orb_geom orb.Geometry) []byte {
+
+	body, err := os.ReadFile("fixtures/microhoods/1108712253.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	f, err := geojson.UnmarshalFeature(featureWithGeometry(t, body, orb_geom))
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal feature, %v", err)
+	}
+
+	f.ID = id
+	f.Properties["wof:id"] = id
+
+	new_body, err := f.MarshalJSON()
+
+	if err != nil {
+		t.Fatalf("Failed to marshal feature, %v", err)
+	}
+
+	return new_body
+}
+
+func TestSpatialDatabasePointInPolygonCandidates(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	mp := orb.MultiPolygon{
+		orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}.ToPolygon(),
+		orb.Bound{Min: orb.Point{5, 5}, Max: orb.Point{6, 6}}.ToPolygon(),
+	}
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, mp))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	c := orb.Point{5.5, 5.5}
+
+	candidates, err := db.PointInPolygonCandidates(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon candidates query, %v", err)
+	}
+
+	if len(candidates) != 1 {
+		t.Fatalf("Expected 1 candidate but got %d", len(candidates))
+	}
+
+	candidate := candidates[0]
+
+	if candidate.FeatureId != "1" || candidate.IsAlt {
+		t.Fatalf("Unexpected candidate %v", candidate)
+	}
+
+	if !candidate.Bounds.Equal(mp[1].Bound()) {
+		t.Fatalf("Expected candidate bounds to be %v but got %v", mp[1].Bound(), candidate.Bounds)
+	}
+
+	part, err := PartFromSpatialId(candidate.Id)
+
+	if err != nil {
+		t.Fatalf("Failed to derive part from %s, %v", candidate.Id, err)
+	}
+
+	if part != 1 {
+		t.Fatalf("Expected candidate to be part 1 but got %d", part)
+	}
+}
+
+func TestSpatialDatabaseReadAlt(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://?index_alt_files=true")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	id := int64(1108712253)
+
+	default_geom := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}.ToPolygon()
+	alt_geom := orb.Bound{Min: orb.Point{10, 10}, Max: orb.Point{11, 11}}.ToPolygon()
+
+	default_body := newTestFeature(t, id, default_geom)
+
+	alt_f, err := geojson.UnmarshalFeature(newTestFeature(t, id, alt_geom))
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal alt feature, %v", err)
+	}
+
+	alt_f.Properties["src:alt_label"] = "quattroshapes"
+	alt_f.Properties["src:geom"] = "quattroshapes"
+
+	alt_body, err := alt_f.MarshalJSON()
+
+	if err != nil {
+		t.Fatalf("Failed to marshal alt feature, %v", err)
+	}
+
+	for _, body := range [][]byte{default_body, alt_body} {
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index feature, %v", err)
+		}
+	}
+
+	tests := map[string]orb.Polygon{
+		"1108712253.geojson":                   default_geom,
+		"1108712253-alt-quattroshapes.geojson": alt_geom,
+	}
+
+	for rel_path, expected := range tests {
+
+		r, err := db.Read(ctx, rel_path)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", rel_path, err)
+		}
+
+		body, err := io.ReadAll(r)
+		r.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read body for %s, %v", rel_path, err)
+		}
+
+		f, err := geojson.UnmarshalFeature(body)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal %s, %v", rel_path, err)
+		}
+
+		if !f.Geometry.Bound().Equal(expected.Bound()) {
+			t.Fatalf("Unexpected geometry for %s, %v", rel_path, f.Geometry.Bound())
+		}
+	}
+
+	// Alternate geometries can not be read unless they have been indexed
+
+	db2, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db2.Close(ctx)
+
+	err = db2.IndexFeature(ctx, default_body)
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	_, err = db2.Read(ctx, "1108712253-alt-quattroshapes.geojson")
+
+	if err == nil {
+		t.Fatalf("Expected alternate geometry read to fail")
+	}
+}
+
+func TestSpatialDatabaseWrite(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	id := int64(1108712253)
+
+	for _, b := range []orb.Bound{
+		{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}},
+		{Min: orb.Point{10, 10}, Max: orb.Point{11, 11}},
+	} {
+
+		body := newTestFeature(t, id, b.ToPolygon())
+
+		wr_len, err := db.Write(ctx, "1108712253.geojson", bytes.NewReader(body))
+
+		if err != nil {
+			t.Fatalf("Failed to write feature, %v", err)
+		}
+
+		if wr_len != int64(len(body)) {
+			t.Fatalf("Expected to write %d bytes but wrote %d", len(body), wr_len)
+		}
+
+		c := b.Center()
+
+		spr, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		if len(spr.Results()) != 1 {
+			t.Fatalf("Expected 1 result but got %d", len(spr.Results()))
+		}
+	}
+
+	c := orb.Point{0.5, 0.5}
+
+	spr, err := db.PointInPolygon(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(spr.Results()) != 0 {
+		t.Fatalf("Expected previous geometry to be replaced but got %d results", len(spr.Results()))
+	}
+
+	_, err = db.Write(ctx, "invalid.geojson", bytes.NewReader([]byte("{}")))
+
+	if err == nil {
+		t.Fatalf("Expected invalid feature to fail")
+	}
+}
+
+func TestNewRTreeSpatialDatabaseParameters(t *testing.T) {
+
+	ctx := context.Background()
+
+	valid := []string{
+		"rtree://?min_children=2&max_children=4",
+		"rtree://?min_children=10&max_children=100&point_epsilon=0.000001",
+		"rtree://?query_workers=1",
+		"rtree://?dsn=:memory:",
+		"rtree://?strict=false&unknown=1",
+	}
+
+	invalid := []string{
+		"rtree://?min_children=0",
+		"rtree://?min_children=10&max_children=15",
+		"rtree://?max_children=a",
+		"rtree://?point_epsilon=0",
+		"rtree://?point_epsilon=-1",
+		"rtree://?query_workers=0",
+		"rtree://?unknown=1",
+	}
+
+	for _, database_uri := range valid {
+
+		db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		db.Close(ctx)
+	}
+
+	for _, database_uri := range invalid {
+
+		_, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", database_uri)
+		}
+	}
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://?min_children=2&max_children=4&point_epsilon=0.000001")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	tree := rtree_db.current.Load().rtree
+
+	if tree.MinChildren != 2 || tree.MaxChildren != 4 {
+		t.Fatalf("Unexpected branching factors %d, %d", tree.MinChildren, tree.MaxChildren)
+	}
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}.ToPolygon()))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	// With the default epsilon a point just outside the lower left corner of the feature would be a candidate
+
+	c := orb.Point{-0.00001, -0.00001}
+
+	candidates, err := db.PointInPolygonCandidates(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon candidates query, %v", err)
+	}
+
+	if len(candidates) != 0 {
+		t.Fatalf("Expected no candidates but got %d", len(candidates))
+	}
+}
+
+func TestSpatialDatabaseExteriorRings(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	outer := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}}.ToRing()
+	hole := orb.Bound{Min: orb.Point{4, 4}, Max: orb.Point{6, 6}}.ToRing()
+	island := orb.Bound{Min: orb.Point{4.5, 4.5}, Max: orb.Point{5.5, 5.5}}.ToRing()
+
+	// A polygon with a hole and an island inside that hole
+
+	mp := orb.MultiPolygon{
+		orb.Polygon{outer, hole},
+		orb.Polygon{island},
+	}
+
+	err = db.IndexFeature(ctx, newTestFeature(t, 1, mp))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	count_entries := len(db.(*RTreeSpatialDatabase).current.Load().lookup["1"])
+
+	if count_entries != 2 {
+		t.Fatalf("Expected 2 rtree entries (one per exterior ring) but got %d", count_entries)
+	}
+
+	tests := map[orb.Point]int{
+		{1, 1}:     1, // outer polygon
+		{4.2, 4.2}: 0, // hole
+		{5, 5}:     1, // island inside the hole
+	}
+
+	for c, expected := range tests {
+
+		spr, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		count := len(spr.Results())
+
+		if count != expected {
+			t.Fatalf("Expected %d results for %v but got %d", expected, c, count)
+		}
+	}
+}
+
+// TestSpatialDatabaseConcurrency mixes concurrent indexing, removal and queries. It is most useful when run with
+// the race detector, for example `go test -race -run TestSpatialDatabaseConcurrency`. Note that the race detector
+// also reports a race during the initialization of the go-whosonfirst-placetypes package, outside of any test,
+// which is unrelated to this package.
+func TestSpatialDatabaseConcurrency(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	// The first half of the features are left alone, the second half are re-indexed (one writer uses the
+	// bulk-loading path) and removed while queries are running
+
+	bodies := newGridFeatures(t, 200)
+	stable := len(bodies) / 2
+
+	err = rtree_db.IndexFeatures(ctx, bodies...)
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	iterations := 100
+
+	if testing.Short() {
+		iterations = 20
+	}
+
+	wg := new(sync.WaitGroup)
+
+	for w := 0; w < 4; w++ {
+
+		wg.Add(1)
+
+		go func(w int) {
+
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+
+				offset := stable + (w*iterations+i)%(len(bodies)-stable)
+				str_id := strconv.Itoa(offset + 1)
+
+				var err error
+
+				if w == 0 {
+					err = rtree_db.IndexFeatures(ctx, bodies[offset])
+				} else {
+					err = db.IndexFeature(ctx, bodies[offset])
+				}
+
+				if err != nil {
+					t.Errorf("Failed to index %s, %v", str_id, err)
+					return
+				}
+
+				// Another writer may have removed the feature already
+
+				db.RemoveFeature(ctx, str_id)
+			}
+		}(w)
+	}
+
+	for q := 0; q < 8; q++ {
+
+		wg.Add(1)
+
+		go func(q int) {
+
+			defer wg.Done()
+
+			for i := 0; i < iterations; i++ {
+
+				offset := (q*iterations + i) % stable
+				str_id := strconv.Itoa(offset + 1)
+
+				x := float64(offset%100) + 0.5
+				y := float64(offset/100) + 0.5
+				c := orb.Point{x, y}
+
+				rsp, err := db.PointInPolygon(ctx, &c)
+
+				if err != nil {
+					t.Errorf("Failed to perform point in polygon query, %v", err)
+					return
+				}
+
+				results := rsp.Results()
+
+				if len(results) != 1 || results[0].Id() != str_id {
+					t.Errorf("Expected point in polygon query at %v to return %s", c, str_id)
+					return
+				}
+
+				nearest, err := rtree_db.Nearest(ctx, &c, 1)
+
+				if err != nil {
+					t.Errorf("Failed to perform nearest query, %v", err)
+					return
+				}
+
+				if len(nearest) != 1 || nearest[0].Place.Id() != str_id {
+					t.Errorf("Expected nearest query at %v to return %s", c, str_id)
+					return
+				}
+
+				b := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{100, 2}}
+
+				_, err = rtree_db.Intersects(ctx, b)
+
+				if err != nil {
+					t.Errorf("Failed to perform intersects query, %v", err)
+					return
+				}
+			}
+		}(q)
+	}
+
+	wg.Wait()
+}
+
+func TestSpatialDatabaseQueryWorkers(t *testing.T) {
+
+	ctx := context.Background()
+
+	bodies := newDenseFeatures(t, 100)
+
+	for _, database_uri := range []string{"rtree://?query_workers=1", "rtree://?query_workers=4", "rtree://?query_workers=1000"} {
+
+		db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, bodies...)
+
+		if err != nil {
+			t.Fatalf("Failed to index features, %v", err)
+		}
+
+		// The origin is contained by every feature, 1,1 is only contained by features whose radius is
+		// greater than its distance from the origin
+
+		tests := map[orb.Point]int{
+			{0, 0}:   100,
+			{1, 1}:   86,
+			{20, 20}: 0,
+		}
+
+		for c, expected := range tests {
+
+			rsp, err := db.PointInPolygon(ctx, &c)
+
+			if err != nil {
+				t.Fatalf("Failed to perform point in polygon query, %v", err)
+			}
+
+			count := len(rsp.Results())
+
+			if count != expected {
+				t.Fatalf("Expected %d results for %v with %s but got %d", expected, c, database_uri, count)
+			}
+		}
+	}
+}
+
+// BenchmarkPointInPolygonWorkers compares point in polygon queries with different numbers of candidates
+// and query workers. Setting query_workers to the number of candidates approximates starting a goroutine
+// for each candidate.
+func BenchmarkPointInPolygonWorkers(b *testing.B) {
+
+	ctx := context.Background()
+
+	for _, count := range []int{5, 50, 500} {
+
+		bodies := newDenseFeatures(b, count)
+
+		workers_list := make([]int, 0)
+
+		for _, workers := range []int{1, 4, runtime.NumCPU(), count} {
+
+			if !slices.Contains(workers_list, workers) {
+				workers_list = append(workers_list, workers)
+			}
+		}
+
+		for _, workers := range workers_list {
+
+			name := fmt.Sprintf("candidates=%d/workers=%d", count, workers)
+
+			b.Run(name, func(b *testing.B) {
+
+				database_uri := fmt.Sprintf("rtree://?query_workers=%d", workers)
+
+				db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+				if err != nil {
+					b.Fatalf("Failed to create new spatial database, %v", err)
+				}
+
+				defer db.Close(ctx)
+
+				err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, bodies...)
+
+				if err != nil {
+					b.Fatalf("Failed to index features, %v", err)
+				}
+
+				c := orb.Point{0, 0}
+
+				b.ReportAllocs()
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+
+					_, err := db.PointInPolygon(ctx, &c)
+
+					if err != nil {
+						b.Fatalf("Failed to perform point in polygon query, %v", err)
+					}
+				}
+			})
+		}
+	}
+}
+
+// newDenseFeatures returns 'count' features whose geometries are 256-sided polygons centered on 0,0 with
+// radii of 1 to 'count' tenths of a degree, so every feature contains the origin. The first feature has ID 1.
+func newDenseFeatures(t testing.TB, count int) [][]byte {
+
+	bodies := make([][]byte, count)
+
+	for i := 0; i < count; i++ {
+
+		radius := float64(i+1) / 10.0
+		ring := make(orb.Ring, 0)
+
+		for j := 0; j <= 256; j++ {
+			a := 2.0 * math.Pi * float64(j%256) / 256.0
+			ring = append(ring, orb.Point{radius * math.Cos(a), radius * math.Sin(a)})
+		}
+
+		bodies[i] = newTestFeature(t, int64(i+1), orb.Polygon{ring})
+	}
+
+	return bodies
+}
+
+func TestSpatialDatabaseQueryErrors(t *testing.T) {
+
+	ctx := context.Background()
+
+	// None of the geometries can be read from the (empty) geometry reader
+
+	reader_uri := fmt.Sprintf("fs://%s", t.TempDir())
+	database_uri := fmt.Sprintf("rtree://?query_workers=4&geometry_reader_uri=%s", url.QueryEscape(reader_uri))
+
+	db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	err = rtree_db.IndexFeatures(ctx, newDenseFeatures(t, 50)...)
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	c := orb.Point{0, 0}
+
+	_, err = db.PointInPolygon(ctx, &c)
+
+	if err == nil {
+		t.Fatalf("Expected point in polygon query to fail")
+	}
+
+	_, err = rtree_db.Intersects(ctx, orb.Bound{Min: c, Max: c})
+
+	if err == nil {
+		t.Fatalf("Expected intersects query to fail")
+	}
+
+	_, err = rtree_db.WithinDistance(ctx, &c, 1000)
+
+	if err == nil {
+		t.Fatalf("Expected within distance query to fail")
+	}
+
+	_, err = rtree_db.Nearest(ctx, &c, 5)
+
+	if err == nil {
+		t.Fatalf("Expected nearest query to fail")
+	}
+}
+
+func TestSpatialDatabaseQueryCancellation(t *testing.T) {
+
+	db, err := NewRTreeSpatialDatabase(context.Background(), "rtree://?query_workers=4")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(context.Background())
+
+	err = db.(*RTreeSpatialDatabase).IndexFeatures(context.Background(), newDenseFeatures(t, 50)...)
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	c := orb.Point{0, 0}
+
+	_, err = db.PointInPolygon(ctx, &c)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected point in polygon query to be cancelled but got %v", err)
+	}
+
+	_, err = db.PointInPolygonCandidates(ctx, &c)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected point in polygon candidates query to be cancelled but got %v", err)
+	}
+
+	_, err = db.(*RTreeSpatialDatabase).Intersects(ctx, orb.Bound{Min: c, Max: c})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected intersects query to be cancelled but got %v", err)
+	}
+}
+
+// TestSpatialDatabaseQueryGoroutines ensures that queries which fail, or are cancelled, part way through do not
+// leave goroutines blocked on the channels used to dispatch results.
+func TestSpatialDatabaseQueryGoroutines(t *testing.T) {
+
+	ctx := context.Background()
+
+	reader_uri := fmt.Sprintf("fs://%s", t.TempDir())
+
+	failing_uri := fmt.Sprintf("rtree://?query_workers=4&geometry_reader_uri=%s", url.QueryEscape(reader_uri))
+	working_uri := "rtree://?query_workers=4"
+
+	bodies := newDenseFeatures(t, 100)
+	databases := make([]*RTreeSpatialDatabase, 0)
+
+	for _, database_uri := range []string{failing_uri, working_uri} {
+
+		db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		rtree_db := db.(*RTreeSpatialDatabase)
+
+		err = rtree_db.IndexFeatures(ctx, bodies...)
+
+		if err != nil {
+			t.Fatalf("Failed to index features, %v", err)
+		}
+
+		databases = append(databases, rtree_db)
+	}
+
+	baseline := runtime.NumGoroutine()
+
+	c := orb.Point{0, 0}
+
+	for i := 0; i < 50; i++ {
+
+		// Fails on the first candidate while other candidates are still being tested
+
+		_, err := databases[0].PointInPolygon(ctx, &c)
+
+		if err == nil {
+			t.Fatalf("Expected point in polygon query to fail")
+		}
+
+		// Cancelled, and no longer read from, while other workers are waiting to dispatch results
+
+		query_ctx, cancel := context.WithCancel(ctx)
+
+		rsp_ch := make(chan spr.StandardPlacesResult)
+		err_ch := make(chan error)
+		done_ch := make(chan bool)
+
+		go databases[1].PointInPolygonWithChannels(query_ctx, rsp_ch, err_ch, done_ch, &c)
+
+		<-rsp_ch
+		time.Sleep(time.Millisecond)
+		cancel()
+	}
+
+	// Goroutines exit asynchronously so allow them some time to do so
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for runtime.NumGoroutine() > baseline {
+
+		if time.Now().After(deadline) {
+
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+
+			t.Fatalf("Expected at most %d goroutines but got %d\n%s", baseline, runtime.NumGoroutine(), buf[:n])
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
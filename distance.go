@@ -0,0 +1,185 @@
+package rtree
+
+import (
+	"math"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// The mean radius of the earth in meters.
+const earthRadius float64 = 6371008.8
+
+// haversineDistance returns the great circle distance, in meters, between 'a' and 'b'.
+func haversineDistance(a orb.Point, b orb.Point) float64 {
+
+	lat1 := radians(a.Y())
+	lat2 := radians(b.Y())
+
+	dlat := lat2 - lat1
+	dlon := radians(b.X() - a.X())
+
+	h := math.Sin(dlat/2)*math.Sin(dlat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dlon/2)*math.Sin(dlon/2)
+
+	return 2 * earthRadius * math.Asin(math.Min(1.0, math.Sqrt(h)))
+}
+
+// distanceToSegment returns the distance, in meters, between 'pt' and the closest point on the segment
+// 'a' to 'b'. The closest point is derived using an equirectangular projection centered on 'pt' which
+// is accurate for all but very long segments. Longitude differences are normalized so segments which
+// cross the antimeridian are measured the short way.
+func distanceToSegment(pt orb.Point, a orb.Point, b orb.Point) float64 {
+
+	cos_lat := math.Cos(radians(pt.Y()))
+
+	// At (or very near) the poles every longitude is (almost) the same point
+
+	if cos_lat < 1e-12 {
+		return math.Min(haversineDistance(pt, a), haversineDistance(pt, b))
+	}
+
+	ax := wrapLongitude(a.X()-pt.X()) * cos_lat
+	ay := a.Y() - pt.Y()
+
+	bx := wrapLongitude(b.X()-pt.X()) * cos_lat
+	by := b.Y() - pt.Y()
+
+	dx := bx - ax
+	dy := by - ay
+
+	t := 0.0
+	length := dx*dx + dy*dy
+
+	if length > 0 {
+		t = -(ax*dx + ay*dy) / length
+		t = math.Max(0.0, math.Min(1.0, t))
+	}
+
+	closest := orb.Point{
+		pt.X() + (ax+t*dx)/cos_lat,
+		pt.Y() + ay + t*dy,
+	}
+
+	return haversineDistance(pt, closest)
+}
+
+// distanceToRing returns the distance, in meters, between 'pt' and the closest segment in 'ring'. It is also
+// used for line strings.
+func distanceToRing(pt orb.Point, ring orb.Ring) float64 {
+
+	if len(ring) == 1 {
+		return haversineDistance(pt, ring[0])
+	}
+
+	d := math.MaxFloat64
+
+	for i := 1; i < len(ring); i++ {
+		d = math.Min(d, distanceToSegment(pt, ring[i-1], ring[i]))
+	}
+
+	return d
+}
+
+// distanceToGeometry returns the distance, in meters, between 'pt' and 'orb_geom'. If 'pt' is contained
+// by a polygon the distance is zero.
+func distanceToGeometry(pt orb.Point, orb_geom orb.Geometry) float64 {
+
+	switch orb_geom.GeoJSONType() {
+	case "Polygon", "MultiPolygon":
+
+		contains_func := antimeridianTestFunc(func(g orb.Geometry) bool {
+
+			for _, poly := range polygons(g) {
+
+				if planar.PolygonContains(poly, pt) {
+					return true
+				}
+			}
+
+			return false
+		})
+
+		if contains_func(orb_geom) {
+			return 0.0
+		}
+
+		d := math.MaxFloat64
+
+		for _, poly := range polygons(orb_geom) {
+
+			for _, ring := range poly {
+				d = math.Min(d, distanceToRing(pt, ring))
+			}
+		}
+
+		return d
+
+	case "Point":
+		return haversineDistance(pt, orb_geom.(orb.Point))
+
+	case "MultiPoint":
+
+		d := math.MaxFloat64
+
+		for _, other := range orb_geom.(orb.MultiPoint) {
+			d = math.Min(d, haversineDistance(pt, other))
+		}
+
+		return d
+
+	case "LineString":
+		return distanceToRing(pt, orb.Ring(orb_geom.(orb.LineString)))
+
+	case "MultiLineString":
+
+		d := math.MaxFloat64
+
+		for _, line := range orb_geom.(orb.MultiLineString) {
+			d = math.Min(d, distanceToRing(pt, orb.Ring(line)))
+		}
+
+		return d
+
+	default:
+		return math.MaxFloat64
+	}
+}
+
+// boundWithRadius returns a bounding box containing all the points within 'meters' of 'pt'. If the bounding
+// box crosses the antimeridian its minimum longitude will be greater than its maximum longitude.
+func boundWithRadius(pt orb.Point, meters float64) orb.Bound {
+
+	dlat := degrees(meters / earthRadius)
+
+	min_lat := math.Max(pt.Y()-dlat, -90.0)
+	max_lat := math.Min(pt.Y()+dlat, 90.0)
+
+	min_lon := -180.0
+	max_lon := 180.0
+
+	// If the bounding box includes a pole then every longitude is in range
+
+	if min_lat > -90.0 && max_lat < 90.0 {
+
+		max_abs_lat := math.Max(math.Abs(min_lat), math.Abs(max_lat))
+		dlon := dlat / math.Cos(radians(max_abs_lat))
+
+		if dlon < 180.0 {
+			min_lon = wrapLongitude(pt.X() - dlon)
+			max_lon = wrapLongitude(pt.X() + dlon)
+		}
+	}
+
+	return orb.Bound{
+		Min: orb.Point{min_lon, min_lat},
+		Max: orb.Point{max_lon, max_lat},
+	}
+}
+
+func radians(d float64) float64 {
+	return d * math.Pi / 180.0
+}
+
+func degrees(r float64) float64 {
+	return r * 180.0 / math.Pi
+}
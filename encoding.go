@@ -0,0 +1,405 @@
+package rtree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/paulmach/orb"
+)
+
+// Cached geometries may optionally be stored in a compact binary encoding, rather than as `geojson.Geometry`
+// instances, and decoded on demand. This trades a little CPU for each candidate tested by a query for a
+// (potentially large) reduction in memory use. Valid encodings are:
+//
+// * `delta` – Coordinates are stored as fixed-point integers (with a precision of 1e-7 degrees, or about
+//   1cm) and each coordinate is encoded as a variable-length delta from the previous coordinate. Coordinates
+//   are rounded to 7 decimal places.
+//
+// There is no Well-Known Binary encoding since it stores coordinates as float64 values and so uses as much
+// memory as `geojson.Geometry` instances.
+
+const geometryEncodingDelta string = "delta"
+
+// The number of fixed-point units per degree for the delta encoding.
+const deltaScale float64 = 1e7
+
+// Geometry type codes. Each geometry (and each member of a multi-geometry or collection) is prefixed by its
+// type code written as a single byte.
+const (
+	geomTypePoint              byte = 1
+	geomTypeLineString         byte = 2
+	geomTypePolygon            byte = 3
+	geomTypeMultiPoint         byte = 4
+	geomTypeMultiLineString    byte = 5
+	geomTypeMultiPolygon       byte = 6
+	geomTypeGeometryCollection byte = 7
+)
+
+// isValidGeometryEncoding returns a boolean value indicating whether 'encoding' is a supported geometry encoding.
+func isValidGeometryEncoding(encoding string) bool {
+
+	switch encoding {
+	case geometryEncodingDelta:
+		return true
+	default:
+		return false
+	}
+}
+
+// encodeGeometry encodes 'orb_geom' using 'encoding'.
+func encodeGeometry(orb_geom orb.Geometry, encoding string) ([]byte, error) {
+
+	var buf bytes.Buffer
+	var w geometryWriter
+
+	switch encoding {
+	case geometryEncodingDelta:
+		w = &deltaWriter{buf: &buf}
+	default:
+		return nil, fmt.Errorf("Unsupported geometry encoding '%s'", encoding)
+	}
+
+	err := writeGeometry(w, orb_geom)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeGeometry decodes 'body', which was produced by `encodeGeometry`, using 'encoding'.
+func decodeGeometry(body []byte, encoding string) (orb.Geometry, error) {
+
+	br := bytes.NewReader(body)
+	var r geometryReader
+
+	switch encoding {
+	case geometryEncodingDelta:
+		r = &deltaReader{r: br}
+	default:
+		return nil, fmt.Errorf("Unsupported geometry encoding '%s'", encoding)
+	}
+
+	return readGeometry(r)
+}
+
+type geometryWriter interface {
+	writeType(byte)
+	writeCount(int)
+	writePoint(orb.Point)
+}
+
+type geometryReader interface {
+	readType() (byte, error)
+	readCount() (int, error)
+	readPoint() (orb.Point, error)
+}
+
+func writeGeometry(w geometryWriter, orb_geom orb.Geometry) error {
+
+	switch g := orb_geom.(type) {
+	case orb.Point:
+		w.writeType(geomTypePoint)
+		w.writePoint(g)
+	case orb.LineString:
+		w.writeType(geomTypeLineString)
+		writePoints(w, g)
+	case orb.Polygon:
+		w.writeType(geomTypePolygon)
+		writeRings(w, g)
+	case orb.MultiPoint:
+
+		w.writeType(geomTypeMultiPoint)
+		w.writeCount(len(g))
+
+		for _, pt := range g {
+			w.writeType(geomTypePoint)
+			w.writePoint(pt)
+		}
+
+	case orb.MultiLineString:
+
+		w.writeType(geomTypeMultiLineString)
+		w.writeCount(len(g))
+
+		for _, line := range g {
+			w.writeType(geomTypeLineString)
+			writePoints(w, line)
+		}
+
+	case orb.MultiPolygon:
+
+		w.writeType(geomTypeMultiPolygon)
+		w.writeCount(len(g))
+
+		for _, poly := range g {
+			w.writeType(geomTypePolygon)
+			writeRings(w, poly)
+		}
+
+	case orb.Collection:
+
+		w.writeType(geomTypeGeometryCollection)
+		w.writeCount(len(g))
+
+		for _, other := range g {
+
+			err := writeGeometry(w, other)
+
+			if err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("Unsupported geometry type %T", orb_geom)
+	}
+
+	return nil
+}
+
+func writePoints(w geometryWriter, points []orb.Point) {
+
+	w.writeCount(len(points))
+
+	for _, pt := range points {
+		w.writePoint(pt)
+	}
+}
+
+func writeRings(w geometryWriter, poly orb.Polygon) {
+
+	w.writeCount(len(poly))
+
+	for _, ring := range poly {
+		writePoints(w, ring)
+	}
+}
+
+func readGeometry(r geometryReader) (orb.Geometry, error) {
+
+	t, err := r.readType()
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch t {
+	case geomTypePoint:
+		return r.readPoint()
+	case geomTypeLineString:
+
+		points, err := readPoints(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return orb.LineString(points), nil
+
+	case geomTypePolygon:
+		return readRings(r)
+	}
+
+	count, err := r.readCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	geoms := make([]orb.Geometry, count)
+
+	for i := 0; i < count; i++ {
+
+		g, err := readGeometry(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		geoms[i] = g
+	}
+
+	switch t {
+	case geomTypeMultiPoint:
+
+		mp := make(orb.MultiPoint, count)
+
+		for i, g := range geoms {
+
+			pt, ok := g.(orb.Point)
+
+			if !ok {
+				return nil, fmt.Errorf("Invalid MultiPoint member %T", g)
+			}
+
+			mp[i] = pt
+		}
+
+		return mp, nil
+
+	case geomTypeMultiLineString:
+
+		ml := make(orb.MultiLineString, count)
+
+		for i, g := range geoms {
+
+			line, ok := g.(orb.LineString)
+
+			if !ok {
+				return nil, fmt.Errorf("Invalid MultiLineString member %T", g)
+			}
+
+			ml[i] = line
+		}
+
+		return ml, nil
+
+	case geomTypeMultiPolygon:
+
+		mp := make(orb.MultiPolygon, count)
+
+		for i, g := range geoms {
+
+			poly, ok := g.(orb.Polygon)
+
+			if !ok {
+				return nil, fmt.Errorf("Invalid MultiPolygon member %T", g)
+			}
+
+			mp[i] = poly
+		}
+
+		return mp, nil
+
+	case geomTypeGeometryCollection:
+		return orb.Collection(geoms), nil
+	default:
+		return nil, fmt.Errorf("Unsupported geometry type %d", t)
+	}
+}
+
+func readPoints(r geometryReader) ([]orb.Point, error) {
+
+	count, err := r.readCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]orb.Point, count)
+
+	for i := 0; i < count; i++ {
+
+		pt, err := r.readPoint()
+
+		if err != nil {
+			return nil, err
+		}
+
+		points[i] = pt
+	}
+
+	return points, nil
+}
+
+func readRings(r geometryReader) (orb.Polygon, error) {
+
+	count, err := r.readCount()
+
+	if err != nil {
+		return nil, err
+	}
+
+	poly := make(orb.Polygon, count)
+
+	for i := 0; i < count; i++ {
+
+		points, err := readPoints(r)
+
+		if err != nil {
+			return nil, err
+		}
+
+		poly[i] = orb.Ring(points)
+	}
+
+	return poly, nil
+}
+
+// deltaWriter writes coordinates as zig-zag varint encoded deltas of fixed-point integers.
+type deltaWriter struct {
+	buf    *bytes.Buffer
+	last_x int64
+	last_y int64
+}
+
+func (w *deltaWriter) writeType(t byte) {
+	w.buf.WriteByte(t)
+}
+
+func (w *deltaWriter) writeCount(n int) {
+	w.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+}
+
+func (w *deltaWriter) writePoint(pt orb.Point) {
+
+	x := int64(math.Round(pt.X() * deltaScale))
+	y := int64(math.Round(pt.Y() * deltaScale))
+
+	w.buf.Write(binary.AppendVarint(nil, x-w.last_x))
+	w.buf.Write(binary.AppendVarint(nil, y-w.last_y))
+
+	w.last_x = x
+	w.last_y = y
+}
+
+// deltaReader reads geometries produced by `deltaWriter`.
+type deltaReader struct {
+	r      *bytes.Reader
+	last_x int64
+	last_y int64
+}
+
+func (r *deltaReader) readType() (byte, error) {
+	return r.r.ReadByte()
+}
+
+func (r *deltaReader) readCount() (int, error) {
+
+	n, err := binary.ReadUvarint(r.r)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if n > uint64(r.r.Len()) {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	return int(n), nil
+}
+
+func (r *deltaReader) readPoint() (orb.Point, error) {
+
+	dx, err := binary.ReadVarint(r.r)
+
+	if err != nil {
+		return orb.Point{}, err
+	}
+
+	dy, err := binary.ReadVarint(r.r)
+
+	if err != nil {
+		return orb.Point{}, err
+	}
+
+	r.last_x += dx
+	r.last_y += dy
+
+	return orb.Point{float64(r.last_x) / deltaScale, float64(r.last_y) / deltaScale}, nil
+}
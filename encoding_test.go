@@ -0,0 +1,199 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+)
+
+func TestGeometryEncoding(t *testing.T) {
+
+	poly := orb.Polygon{
+		orb.Ring{{-122.4194155, 37.7749295}, {-122.4, 37.7749295}, {-122.4, 37.8}, {-122.4194155, 37.7749295}},
+		orb.Ring{{-122.41, 37.78}, {-122.405, 37.78}, {-122.405, 37.785}, {-122.41, 37.78}},
+	}
+
+	geoms := []orb.Geometry{
+		orb.Point{-71.120168, 42.376015},
+		orb.LineString{{0, 0}, {1.5, -1.25}, {179.9999999, -89.9999999}},
+		poly,
+		orb.MultiPoint{{1, 1}, {-1, -1}},
+		orb.MultiLineString{{{0, 0}, {1, 1}}, {{2, 2}, {3, 3}}},
+		orb.MultiPolygon{poly, orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{1, 1}}.ToPolygon()},
+		orb.Collection{orb.Point{1, 2}, poly},
+	}
+
+	for _, encoding := range []string{geometryEncodingDelta} {
+
+		for _, g := range geoms {
+
+			body, err := encodeGeometry(g, encoding)
+
+			if err != nil {
+				t.Fatalf("Failed to encode %s with %s, %v", g.GeoJSONType(), encoding, err)
+			}
+
+			decoded, err := decodeGeometry(body, encoding)
+
+			if err != nil {
+				t.Fatalf("Failed to decode %s with %s, %v", g.GeoJSONType(), encoding, err)
+			}
+
+			if decoded.GeoJSONType() != g.GeoJSONType() {
+				t.Fatalf("Expected %s with %s but got %s", g.GeoJSONType(), encoding, decoded.GeoJSONType())
+			}
+
+			b1 := g.Bound()
+			b2 := decoded.Bound()
+
+			for _, d := range []float64{b1.Min.X() - b2.Min.X(), b1.Min.Y() - b2.Min.Y(), b1.Max.X() - b2.Max.X(), b1.Max.Y() - b2.Max.Y()} {
+
+				if math.Abs(d) > 1e-7 {
+					t.Fatalf("Expected %s to be within 1e-7 degrees with %s, got %v", g.GeoJSONType(), encoding, decoded)
+				}
+			}
+		}
+
+		_, err := decodeGeometry([]byte{9}, encoding)
+
+		if err == nil {
+			t.Fatalf("Expected invalid %s geometry to fail", encoding)
+		}
+	}
+}
+
+func TestSpatialDatabaseGeometryEncoding(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[int64]Criteria{
+		1108712253: Criteria{Longitude: -71.120168, Latitude: 42.376015, IsCurrent: 1},   // Old Cambridge
+		420561633:  Criteria{Longitude: -122.395268, Latitude: 37.794893, IsCurrent: 0},  // Superbowl City
+		420780729:  Criteria{Longitude: -122.421529, Latitude: 37.743168, IsCurrent: -1}, // Liminal Zone of Deliciousness
+	}
+
+	for _, encoding := range []string{geometryEncodingDelta} {
+
+		database_uri := fmt.Sprintf("rtree://?geometry_encoding=%s", encoding)
+
+		db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		err = IndexDatabaseWithIterator(ctx, db, `directory://?_exclude=\.go$`, "fixtures/microhoods")
+
+		if err != nil {
+			t.Fatalf("Failed to index spatial database for %s, %v", database_uri, err)
+		}
+
+		for expected, criteria := range tests {
+
+			c, err := geo.NewCoordinate(criteria.Longitude, criteria.Latitude)
+
+			if err != nil {
+				t.Fatalf("Failed to create new coordinate, %v", err)
+			}
+
+			spr, err := db.PointInPolygon(ctx, c)
+
+			if err != nil {
+				t.Fatalf("Failed to perform point in polygon query for %s, %v", database_uri, err)
+			}
+
+			found := false
+
+			for _, s := range spr.Results() {
+
+				if s.Id() == fmt.Sprintf("%d", expected) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				t.Fatalf("Expected to find %d for %s", expected, database_uri)
+			}
+		}
+	}
+
+	_, err := database.NewSpatialDatabase(ctx, "rtree://?geometry_encoding=unknown")
+
+	if err == nil {
+		t.Fatalf("Expected invalid geometry encoding to fail")
+	}
+}
+
+// BenchmarkGeometryEncodingMemory reports the heap used by the microhoods fixtures for each geometry encoding. To
+// measure a different dataset, for example a full repo, set the RTREE_BENCHMARK_ITERATOR_URI and
+// RTREE_BENCHMARK_ITERATOR_SOURCE environment variables.
+func BenchmarkGeometryEncodingMemory(b *testing.B) {
+
+	ctx := context.Background()
+
+	iterator_uri := `directory://?_exclude=\.go$`
+	iterator_source := "fixtures/microhoods"
+
+	if v := os.Getenv("RTREE_BENCHMARK_ITERATOR_URI"); v != "" {
+		iterator_uri = v
+	}
+
+	if v := os.Getenv("RTREE_BENCHMARK_ITERATOR_SOURCE"); v != "" {
+		iterator_source = v
+	}
+
+	for _, encoding := range []string{"", geometryEncodingDelta} {
+
+		name := encoding
+
+		if name == "" {
+			name = "geojson"
+		}
+
+		b.Run(name, func(b *testing.B) {
+
+			var heap_bytes int64
+
+			for i := 0; i < b.N; i++ {
+
+				var before runtime.MemStats
+				var after runtime.MemStats
+
+				runtime.GC()
+				runtime.ReadMemStats(&before)
+
+				db, err := database.NewSpatialDatabase(ctx, fmt.Sprintf("rtree://?geometry_encoding=%s", encoding))
+
+				if err != nil {
+					b.Fatalf("Failed to create new spatial database, %v", err)
+				}
+
+				err = IndexDatabaseWithIterator(ctx, db, iterator_uri, iterator_source)
+
+				if err != nil {
+					b.Fatalf("Failed to index spatial database, %v", err)
+				}
+
+				runtime.GC()
+				runtime.ReadMemStats(&after)
+
+				// HeapAlloc may shrink between the two readings so don't subtract as unsigned integers
+				heap_bytes += int64(after.HeapAlloc) - int64(before.HeapAlloc)
+
+				runtime.KeepAlive(db)
+			}
+
+			b.ReportMetric(float64(heap_bytes)/float64(b.N), "heap-bytes/op")
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dhconnelly/rtreego"
+)
+
+// A generation is a complete, self-consistent, copy of the index: the rtree, the lookup table of rtree entries
+// for each feature and the cache of SPR records and geometries that those entries point to. Queries load the
+// current generation once and use it for their entire lifetime so a query never sees part of one generation and
+// part of another. Individual writes (`IndexFeature`, `RemoveFeature`, etc.) update the current generation in
+// place while `Rebuild` builds an entirely new generation in the background and swaps it in atomically.
+
+type generation struct {
+	rtree  *rtreego.Rtree
+	lookup map[string][]*RTreeSpatialIndex
+	cache  featureCache
+	// If not nil the recently used geometries read from the geometry reader. Items are removed whenever the
+	// cache item for a feature is replaced or removed.
+	geometry_cache featureCache
+	// The number of queries currently using the generation
+	refs *atomic.Int64
+	// Whether the generation has been replaced, in which case it is closed once refs drops to zero
+	retired    *atomic.Bool
+	close_once *sync.Once
+	close_err  error
+}
+
+func newGeneration(cache featureCache, geometry_cache featureCache, min_children int, max_children int) *generation {
+
+	g := &generation{
+		rtree:          rtreego.NewTree(2, min_children, max_children),
+		lookup:         make(map[string][]*RTreeSpatialIndex),
+		cache:          cache,
+		geometry_cache: geometry_cache,
+		refs:           new(atomic.Int64),
+		retired:        new(atomic.Bool),
+		close_once:     new(sync.Once),
+	}
+
+	return g
+}
+
+// insertIndex adds 'sp' to the rtree and to the lookup table of entries for its feature ID. It is
+// assumed that the caller has acquired a write lock.
+func (g *generation) insertIndex(sp *RTreeSpatialIndex) {
+	g.rtree.Insert(sp)
+	g.lookup[sp.FeatureId] = append(g.lookup[sp.FeatureId], sp)
+}
+
+// removeIndex removes all the entries for 'feature_id' whose alt label matches 'alt_label' from the rtree
+// and the lookup table of entries for that feature ID. It is assumed that the caller has acquired a write lock.
+func (g *generation) removeIndex(feature_id string, alt_label string) error {
+
+	entries, ok := g.lookup[feature_id]
+
+	if !ok {
+		return nil
+	}
+
+	remaining := make([]*RTreeSpatialIndex, 0)
+
+	for _, sp := range entries {
+
+		if sp.AltLabel != alt_label {
+			remaining = append(remaining, sp)
+			continue
+		}
+
+		ok := g.rtree.Delete(sp)
+
+		if !ok {
+			return fmt.Errorf("Failed to remove %s from rtree", sp.Id)
+		}
+	}
+
+	if len(remaining) == 0 {
+		delete(g.lookup, feature_id)
+	} else {
+		g.lookup[feature_id] = remaining
+	}
+
+	return nil
+}
+
+// replaceRecord replaces any existing entries, and cache item, for the feature (and alt label) in 'rec' with
+// those in 'rec'. The cache item is stored first so that if it can not be stored the existing entries, and cache
+// item, are left unchanged. It is assumed that the caller has acquired a write lock.
+func (g *generation) replaceRecord(ctx context.Context, rec *indexRecord) error {
+
+	err := g.setCacheItem(ctx, rec.CacheKey, rec.CacheItem)
+
+	if err != nil {
+		return err
+	}
+
+	err = g.removeIndex(rec.FeatureId, rec.AltLabel)
+
+	if err != nil {
+		return fmt.Errorf("Failed to remove existing entries for %s, %w", rec.CacheKey, err)
+	}
+
+	for _, sp := range rec.Entries {
+		g.insertIndex(sp)
+	}
+
+	return nil
+}
+
+// A bulkLoad is the lookup table and rtree that a generation will have once a set of records has been loaded in
+// to it. Building the rtree is by far the most expensive part of a bulk load so it is done by `prepareLoad`, which
+// does not modify the generation, and then swapped in by `applyLoad`.
+type bulkLoad struct {
+	records []*indexRecord
+	lookup  map[string][]*RTreeSpatialIndex
+	rtree   *rtreego.Rtree
+}
+
+// prepareLoad returns the lookup table and rtree, built using rtreego's bulk-loading algorithm, that 'g' will have
+// once any existing entries for the features in 'records' are replaced with those in 'records'. It does not modify
+// 'g' so it may be called while queries are using 'g', but it is assumed that the caller prevents any other writes
+// until the result has been applied.
+func (g *generation) prepareLoad(records []*indexRecord) *bulkLoad {
+
+	lookup := make(map[string][]*RTreeSpatialIndex, len(g.lookup))
+
+	for feature_id, entries := range g.lookup {
+		lookup[feature_id] = entries
+	}
+
+	for _, rec := range records {
+
+		if rec == nil {
+			continue
+		}
+
+		remaining := make([]*RTreeSpatialIndex, 0)
+
+		for _, sp := range lookup[rec.FeatureId] {
+
+			if sp.AltLabel != rec.AltLabel {
+				remaining = append(remaining, sp)
+			}
+		}
+
+		remaining = append(remaining, rec.Entries...)
+
+		if len(remaining) == 0 {
+			delete(lookup, rec.FeatureId)
+		} else {
+			lookup[rec.FeatureId] = remaining
+		}
+	}
+
+	objs := make([]rtreego.Spatial, 0)
+
+	for _, entries := range lookup {
+
+		for _, sp := range entries {
+			objs = append(objs, sp)
+		}
+	}
+
+	l := &bulkLoad{
+		records: records,
+		lookup:  lookup,
+		rtree:   rtreego.NewTree(2, g.rtree.MinChildren, g.rtree.MaxChildren, objs...),
+	}
+
+	return l
+}
+
+// storeRecords stores the cache items for 'records', replacing any existing cache items. If a cache item can not
+// be stored then the cache items which have already been stored are removed, or replaced by the cache items they
+// replaced, before an error is returned. It does not modify the lookup table or rtree of 'g' so it may be called
+// while queries are using 'g', but it is assumed that the caller prevents any other writes.
+func (g *generation) storeRecords(ctx context.Context, records []*indexRecord) error {
+
+	// The keys whose cache items have been stored, in order, and the cache items they replaced (or nil)
+
+	stored := make([]string, 0)
+	previous := make(map[string]*RTreeCache)
+
+	rollback := func(err error) error {
+
+		for _, cache_key := range stored {
+
+			var rollback_err error
+
+			cache_item := previous[cache_key]
+
+			if cache_item != nil {
+				rollback_err = g.setCacheItem(ctx, cache_key, cache_item)
+			} else {
+				rollback_err = g.cache.Delete(ctx, cache_key)
+			}
+
+			if rollback_err != nil {
+				return fmt.Errorf("%w (and failed to restore cache item for %s, %w)", err, cache_key, rollback_err)
+			}
+		}
+
+		return err
+	}
+
+	for _, rec := range records {
+
+		if rec == nil {
+			continue
+		}
+
+		_, seen := previous[rec.CacheKey]
+
+		if !seen {
+
+			// Only features which are already indexed can have an existing cache item
+
+			var cache_item *RTreeCache
+
+			_, indexed := g.lookup[rec.FeatureId]
+
+			if indexed {
+				cache_item, _ = g.cache.Get(ctx, rec.CacheKey)
+			}
+
+			previous[rec.CacheKey] = cache_item
+		}
+
+		err := g.setCacheItem(ctx, rec.CacheKey, rec.CacheItem)
+
+		if err != nil {
+			return rollback(err)
+		}
+
+		if !seen {
+			stored = append(stored, rec.CacheKey)
+		}
+	}
+
+	return nil
+}
+
+// applyLoad replaces the lookup table and rtree of 'g' with those in 'l'. It is assumed that the cache items for the
+// records in 'l' have been stored, using `storeRecords`, and that the caller has acquired a write lock.
+func (g *generation) applyLoad(ctx context.Context, l *bulkLoad) error {
+
+	g.lookup = l.lookup
+	g.rtree = l.rtree
+
+	// Queries may have cached (now out of date) geometries for these features while their cache items were
+	// being stored without the write lock
+
+	for _, rec := range l.records {
+
+		if rec == nil {
+			continue
+		}
+
+		err := g.removeGeometry(ctx, rec.CacheKey)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadRecords replaces any existing entries, and cache items, for the features in 'records' and then rebuilds
+// the rtree using rtreego's bulk-loading algorithm. If a cache item can not be stored then 'g' is left unchanged.
+// It is assumed that the caller has acquired a write lock.
+func (g *generation) loadRecords(ctx context.Context, records []*indexRecord) error {
+
+	err := g.storeRecords(ctx, records)
+
+	if err != nil {
+		return err
+	}
+
+	return g.applyLoad(ctx, g.prepareLoad(records))
+}
+
+// removeFeature removes all the entries, and cache items, for 'id'. It is assumed that the caller has acquired
+// a write lock.
+func (g *generation) removeFeature(ctx context.Context, id string) error {
+
+	entries, ok := g.lookup[id]
+
+	if !ok {
+		return fmt.Errorf("Failed to remove %s from rtree, not found", id)
+	}
+
+	alt_labels := make(map[string]bool)
+
+	for _, sp := range entries {
+		alt_labels[sp.AltLabel] = true
+	}
+
+	for alt_label := range alt_labels {
+
+		err := g.removeIndex(id, alt_label)
+
+		if err != nil {
+			return err
+		}
+
+		cache_key := cacheKey(id, alt_label)
+
+		err = g.cache.Delete(ctx, cache_key)
+
+		if err != nil {
+			return fmt.Errorf("Failed to remove cache item for %s, %w", cache_key, err)
+		}
+
+		err = g.removeGeometry(ctx, cache_key)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setCacheItem stores 'cache_item' for 'cache_key', replacing any existing cache item and removing any
+// geometry cached for it. It is assumed that the caller prevents any other writes.
+func (g *generation) setCacheItem(ctx context.Context, cache_key string, cache_item *RTreeCache) error {
+
+	err := g.cache.Set(ctx, cache_key, cache_item)
+
+	if err != nil {
+		return fmt.Errorf("Failed to store cache item for %s, %w", cache_key, err)
+	}
+
+	return g.removeGeometry(ctx, cache_key)
+}
+
+// removeGeometry removes the geometry cached for 'cache_key', if present, from the geometry cache.
+func (g *generation) removeGeometry(ctx context.Context, cache_key string) error {
+
+	if g.geometry_cache == nil {
+		return nil
+	}
+
+	err := g.geometry_cache.Delete(ctx, cache_key)
+
+	if err != nil {
+		return fmt.Errorf("Failed to remove cached geometry for %s, %w", cache_key, err)
+	}
+
+	return nil
+}
+
+// isIndexed returns a boolean value indicating whether 'sp' is in the generation's lookup table. It is assumed
+// that the caller has acquired a read lock.
+func (g *generation) isIndexed(sp *RTreeSpatialIndex) bool {
+
+	for _, other := range g.lookup[sp.FeatureId] {
+
+		if other == sp {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (g *generation) retrieveCache(ctx context.Context, sp *RTreeSpatialIndex) (*RTreeCache, error) {
+
+	cache_key := cacheKey(sp.FeatureId, sp.AltLabel)
+
+	return g.cache.Get(ctx, cache_key)
+}
+
+// release signals that a query is no longer using the generation, closing it if it has been retired and
+// this was the last query using it.
+func (g *generation) release(ctx context.Context) {
+
+	if g.refs.Add(-1) == 0 && g.retired.Load() {
+		g.close(ctx)
+	}
+}
+
+// retire marks the generation as replaced. It is closed immediately if no queries are using it or otherwise
+// when the last query using it releases it.
+func (g *generation) retire(ctx context.Context) {
+
+	g.retired.Store(true)
+
+	if g.refs.Load() == 0 {
+		g.close(ctx)
+	}
+}
+
+// close closes the generation's cache (and geometry cache). If the generation has been retired and its cache is
+// a disk cache stored in `cache_root` then the cache's files are removed, since they will never be read again,
+// rather than being left behind. It is safe to call close more than once.
+func (g *generation) close(ctx context.Context) error {
+
+	g.close_once.Do(func() {
+
+		if g.geometry_cache != nil {
+			g.geometry_cache.Close(ctx)
+		}
+
+		dc, ok := g.cache.(*diskCache)
+
+		if ok && g.retired.Load() && !dc.is_temp {
+
+			err := dc.clear(ctx)
+
+			if err != nil {
+				g.close_err = err
+				return
+			}
+		}
+
+		g.close_err = g.cache.Close(ctx)
+	})
+
+	return g.close_err
+}
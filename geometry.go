@@ -0,0 +1,354 @@
+package rtree
+
+import (
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/planar"
+)
+
+// geometryIntersectsBound returns a boolean value indicating whether 'orb_geom' shares any points with 'b'.
+func geometryIntersectsBound(orb_geom orb.Geometry, b orb.Bound) bool {
+
+	if !b.Intersects(orb_geom.Bound()) {
+		return false
+	}
+
+	switch orb_geom.GeoJSONType() {
+	case "Polygon":
+		return polygonsIntersect(orb_geom.(orb.Polygon), b.ToPolygon())
+	case "MultiPolygon":
+
+		for _, poly := range orb_geom.(orb.MultiPolygon) {
+
+			if polygonsIntersect(poly, b.ToPolygon()) {
+				return true
+			}
+		}
+
+		return false
+
+	case "Point":
+		return b.Contains(orb_geom.(orb.Point))
+
+	case "MultiPoint":
+
+		for _, pt := range orb_geom.(orb.MultiPoint) {
+
+			if b.Contains(pt) {
+				return true
+			}
+		}
+
+		return false
+
+	case "LineString":
+		return lineStringIntersectsBound(orb_geom.(orb.LineString), b)
+
+	case "MultiLineString":
+
+		for _, line := range orb_geom.(orb.MultiLineString) {
+
+			if lineStringIntersectsBound(line, b) {
+				return true
+			}
+		}
+
+		return false
+
+	default:
+		return false
+	}
+}
+
+// lineStringIntersectsBound returns a boolean value indicating whether any part of 'line' falls within 'b'.
+func lineStringIntersectsBound(line orb.LineString, b orb.Bound) bool {
+
+	for _, pt := range line {
+
+		if b.Contains(pt) {
+			return true
+		}
+	}
+
+	return ringsCross(orb.Ring(line), b.ToRing())
+}
+
+// polygonsIntersect returns a boolean value indicating whether 'a' and 'b' share any points, including
+// their boundaries. Interior rings (holes) are accounted for.
+func polygonsIntersect(a orb.Polygon, b orb.Polygon) bool {
+
+	if !a.Bound().Intersects(b.Bound()) {
+		return false
+	}
+
+	// Any vertex of one polygon inside the other
+
+	for _, ring := range a {
+
+		for _, pt := range ring {
+
+			if planar.PolygonContains(b, pt) {
+				return true
+			}
+		}
+	}
+
+	for _, ring := range b {
+
+		for _, pt := range ring {
+
+			if planar.PolygonContains(a, pt) {
+				return true
+			}
+		}
+	}
+
+	// Any edge of one polygon crossing an edge of the other
+
+	for _, ring_a := range a {
+
+		for _, ring_b := range b {
+
+			if ringsCross(ring_a, ring_b) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// ringsCross returns a boolean value indicating whether any segment of 'a' intersects any segment of 'b'.
+func ringsCross(a orb.Ring, b orb.Ring) bool {
+
+	if !a.Bound().Intersects(b.Bound()) {
+		return false
+	}
+
+	for i := 1; i < len(a); i++ {
+
+		for j := 1; j < len(b); j++ {
+
+			if segmentsIntersect(a[i-1], a[i], b[j-1], b[j]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// segmentsIntersect returns a boolean value indicating whether the segment 'a1' to 'a2' and the
+// segment 'b1' to 'b2' share any points.
+func segmentsIntersect(a1 orb.Point, a2 orb.Point, b1 orb.Point, b2 orb.Point) bool {
+
+	d1 := orientation(b1, b2, a1)
+	d2 := orientation(b1, b2, a2)
+	d3 := orientation(a1, a2, b1)
+	d4 := orientation(a1, a2, b2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	if d1 == 0 && onSegment(b1, b2, a1) {
+		return true
+	}
+
+	if d2 == 0 && onSegment(b1, b2, a2) {
+		return true
+	}
+
+	if d3 == 0 && onSegment(a1, a2, b1) {
+		return true
+	}
+
+	if d4 == 0 && onSegment(a1, a2, b2) {
+		return true
+	}
+
+	return false
+}
+
+// orientation returns the cross product of the vectors 'a' to 'b' and 'a' to 'c'. A positive value
+// means 'c' is to the left of 'a' to 'b', negative to the right and zero that the points are collinear.
+func orientation(a orb.Point, b orb.Point, c orb.Point) float64 {
+	return (b.X()-a.X())*(c.Y()-a.Y()) - (b.Y()-a.Y())*(c.X()-a.X())
+}
+
+// onSegment returns a boolean value indicating whether 'p', which is assumed to be collinear with
+// 'a' and 'b', falls within the bounds of the segment 'a' to 'b'.
+func onSegment(a orb.Point, b orb.Point, p orb.Point) bool {
+
+	return p.X() >= min(a.X(), b.X()) && p.X() <= max(a.X(), b.X()) &&
+		p.Y() >= min(a.Y(), b.Y()) && p.Y() <= max(a.Y(), b.Y())
+}
+
+// polygonContainsPolygon returns a boolean value indicating whether every point of 'b' is also a point of 'a'.
+func polygonContainsPolygon(a orb.Polygon, b orb.Polygon) bool {
+
+	if len(a) == 0 || len(b) == 0 {
+		return false
+	}
+
+	a_bound := a.Bound()
+	b_bound := b.Bound()
+
+	if !a_bound.Contains(b_bound.Min) || !a_bound.Contains(b_bound.Max) {
+		return false
+	}
+
+	// Every vertex (and the midpoint of every segment) of the exterior ring of 'b' must be
+	// inside (or on the boundary of) 'a'
+
+	for i, pt := range b[0] {
+
+		if !planar.PolygonContains(a, pt) {
+			return false
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		prev := b[0][i-1]
+		mid := orb.Point{(prev.X() + pt.X()) / 2.0, (prev.Y() + pt.Y()) / 2.0}
+
+		if !planar.PolygonContains(a, mid) {
+			return false
+		}
+	}
+
+	// The exterior ring of 'b' must not cross any of the rings (including holes) in 'a'
+
+	for _, ring := range a {
+
+		if ringsCrossProperly(ring, b[0]) {
+			return false
+		}
+	}
+
+	// No part of 'a' (for example a hole) may poke in to the interior of 'b'
+
+	for _, ring := range a {
+
+		for _, pt := range ring {
+
+			if polygonInteriorContains(b, pt) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// multiPolygonContainsPolygon returns a boolean value indicating whether 'b' is contained by any one of the
+// polygons in 'mp'.
+func multiPolygonContainsPolygon(mp orb.MultiPolygon, b orb.Polygon) bool {
+
+	for _, poly := range mp {
+
+		if polygonContainsPolygon(poly, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// polygonInteriorContains returns a boolean value indicating whether 'pt' is inside 'p' and not on
+// any of its boundaries.
+func polygonInteriorContains(p orb.Polygon, pt orb.Point) bool {
+
+	if !planar.PolygonContains(p, pt) {
+		return false
+	}
+
+	for _, ring := range p {
+
+		if pointOnRing(ring, pt) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// pointOnRing returns a boolean value indicating whether 'pt' falls on any of the segments in 'ring'.
+func pointOnRing(ring orb.Ring, pt orb.Point) bool {
+
+	for i := 1; i < len(ring); i++ {
+
+		if orientation(ring[i-1], ring[i], pt) == 0 && onSegment(ring[i-1], ring[i], pt) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ringsCrossProperly returns a boolean value indicating whether any segment of 'a' crosses any segment
+// of 'b' at a single point which is not an endpoint of either segment.
+func ringsCrossProperly(a orb.Ring, b orb.Ring) bool {
+
+	if !a.Bound().Intersects(b.Bound()) {
+		return false
+	}
+
+	for i := 1; i < len(a); i++ {
+
+		for j := 1; j < len(b); j++ {
+
+			d1 := orientation(b[j-1], b[j], a[i-1])
+			d2 := orientation(b[j-1], b[j], a[i])
+			d3 := orientation(a[i-1], a[i], b[j-1])
+			d4 := orientation(a[i-1], a[i], b[j])
+
+			if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// geometryPart returns the part at offset 'part' of 'orb_geom' if it is a multi-part geometry or 'orb_geom'
+// otherwise (or if 'part' is out of range).
+func geometryPart(orb_geom orb.Geometry, part int) orb.Geometry {
+
+	switch g := orb_geom.(type) {
+	case orb.MultiPolygon:
+
+		if part >= 0 && part < len(g) {
+			return g[part]
+		}
+
+	case orb.MultiLineString:
+
+		if part >= 0 && part < len(g) {
+			return g[part]
+		}
+
+	case orb.MultiPoint:
+
+		if part >= 0 && part < len(g) {
+			return g[part]
+		}
+	}
+
+	return orb_geom
+}
+
+// polygons returns the list of polygons in 'orb_geom' which is expected to be a Polygon or MultiPolygon.
+func polygons(orb_geom orb.Geometry) []orb.Polygon {
+
+	switch orb_geom.GeoJSONType() {
+	case "Polygon":
+		return []orb.Polygon{orb_geom.(orb.Polygon)}
+	case "MultiPolygon":
+		return []orb.Polygon(orb_geom.(orb.MultiPolygon))
+	default:
+		return nil
+	}
+}
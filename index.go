@@ -0,0 +1,148 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/paulmach/orb/geojson"
+	"github.com/tidwall/gjson"
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+// The methods in this file mirror those in whosonfirst/go-whosonfirst-spatial/database except that they
+// will index features of any geometry type (Point, LineString, etc.) rather than only Polygon and
+// MultiPolygon features.
+
+// IndexDatabaseWithIterator indexes 'db' with the records emitted by a whosonfirst/go-whosonfirst-iterate/v2
+// iterator. Unlike `database.IndexDatabaseWithIterator` records of all geometry types are indexed. If 'db' is a
+// `RTreeSpatialDatabase` then all the records are parsed first and the rtree is bulk-loaded once (see
+// `IndexFeatures`) in which case, if any record fails to be indexed, the database is left unchanged.
+func IndexDatabaseWithIterator(ctx context.Context, db database.SpatialDatabase, iterator_uri string, iterator_sources ...string) error {
+
+	rtree_db, ok := db.(*RTreeSpatialDatabase)
+
+	if ok {
+
+		records, err := rtree_db.iteratorIndexRecords(ctx, iterator_uri, iterator_sources...)
+
+		if err != nil {
+			return err
+		}
+
+		return rtree_db.loadIndexRecords(ctx, records)
+	}
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		select {
+		case <-ctx.Done():
+			return nil
+		default:
+			// pass
+		}
+
+		err := IndexDatabaseWithReader(ctx, db, r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index %s, %w", path, err)
+		}
+
+		return nil
+	}
+
+	iter, err := iterator.NewIterator(ctx, iterator_uri, iter_cb)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create iterator, %w", err)
+	}
+
+	err = iter.IterateURIs(ctx, iterator_sources...)
+
+	if err != nil {
+		return fmt.Errorf("Failed to iterate URIs, %w", err)
+	}
+
+	return nil
+}
+
+// IndexDatabaseWithFS indexes 'db' with the records in 'index_fs'. Unlike `database.IndexDatabaseWithFS`
+// records of all geometry types are indexed.
+func IndexDatabaseWithFS(ctx context.Context, db database.SpatialDatabase, index_fs fs.FS) error {
+
+	walk_func := func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		r, err := index_fs.Open(path)
+
+		if err != nil {
+			return fmt.Errorf("Failed to open %s for reading, %w", path, err)
+		}
+
+		defer r.Close()
+
+		err = IndexDatabaseWithReader(ctx, db, r)
+
+		if err != nil {
+			return fmt.Errorf("Failed to index %s, %w", path, err)
+		}
+
+		return nil
+	}
+
+	return fs.WalkDir(index_fs, ".", walk_func)
+}
+
+// IndexDatabaseWithReader indexes 'db' with the GeoJSON Feature, or FeatureCollection, read from 'r'. Unlike
+// `database.IndexDatabaseWithReader` records of all geometry types are indexed.
+func IndexDatabaseWithReader(ctx context.Context, db database.SpatialDatabase, r io.Reader) error {
+
+	body, err := io.ReadAll(r)
+
+	if err != nil {
+		return fmt.Errorf("Failed to read document, %w", err)
+	}
+
+	_, err = indexRecordBody(ctx, db, body)
+	return err
+}
+
+// featureBodies returns the list of GeoJSON Features in 'body' which may be a Feature or a FeatureCollection.
+func featureBodies(body []byte) ([][]byte, error) {
+
+	t_rsp := gjson.GetBytes(body, "type")
+
+	if t_rsp.String() != "FeatureCollection" {
+		return [][]byte{body}, nil
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal record in to a FeatureCollection, %w", err)
+	}
+
+	feature_bodies := make([][]byte, len(fc.Features))
+
+	for i, f := range fc.Features {
+
+		f_body, err := f.MarshalJSON()
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to marshal Feature at offset %d in record, %w", i, err)
+		}
+
+		feature_bodies[i] = f_body
+	}
+
+	return feature_bodies, nil
+}
@@ -0,0 +1,154 @@
+package rtree
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestIndexDatabaseWithIteratorPoints(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	// Exclude the microhoods.go file which is not a GeoJSON document
+
+	err = IndexDatabaseWithIterator(ctx, db, `directory://?_exclude=\.go$`, "fixtures/microhoods")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	_, ok := rtree_db.current.Load().lookup["1108712103"]
+
+	if !ok {
+		t.Fatalf("Expected Point feature 1108712103 to be indexed")
+	}
+
+	coord := orb.Point{-71.05606307350565, 42.28279281255512}
+
+	b := orb.Bound{
+		Min: orb.Point{coord.X() - 0.0001, coord.Y() - 0.0001},
+		Max: orb.Point{coord.X() + 0.0001, coord.Y() + 0.0001},
+	}
+
+	rsp, err := rtree_db.Intersects(ctx, b)
+
+	if err != nil {
+		t.Fatalf("Failed to perform intersects query, %v", err)
+	}
+
+	ids := make([]string, 0)
+
+	for _, s := range rsp.Results() {
+		ids = append(ids, s.Id())
+	}
+
+	if !slices.Contains(ids, "1108712103") {
+		t.Fatalf("Expected intersects results to contain 1108712103, got %v", ids)
+	}
+}
+
+func TestSpatialDatabasePointsAndLines(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	features := map[int64]orb.Geometry{
+		1: orb.Point{1, 1},
+		// A horizontal line, which has a zero-height bounding box
+		2: orb.LineString{{2, 2}, {3, 2}},
+		3: orb.MultiPoint{{10, 10}, {11, 11}},
+	}
+
+	for id, geom := range features {
+
+		err := db.IndexFeature(ctx, newTestFeature(t, id, geom))
+
+		if err != nil {
+			t.Fatalf("Failed to index feature %d, %v", id, err)
+		}
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	tests := map[orb.Bound][]string{
+		orb.Bound{Min: orb.Point{0.5, 0.5}, Max: orb.Point{1.5, 1.5}}:     []string{"1"},
+		orb.Bound{Min: orb.Point{2.5, 1.5}, Max: orb.Point{2.6, 2.5}}:     []string{"2"},
+		orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{12, 12}}:           []string{"1", "2", "3"},
+		orb.Bound{Min: orb.Point{10.2, 10.2}, Max: orb.Point{10.8, 10.8}}: []string{},
+	}
+
+	for b, expected := range tests {
+
+		rsp, err := rtree_db.Intersects(ctx, b)
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, s := range rsp.Results() {
+			ids = append(ids, s.Id())
+		}
+
+		slices.Sort(ids)
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Expected %v for %v but got %v", expected, b, ids)
+		}
+	}
+
+	pt := orb.Point{2.5, 2.1}
+
+	nearest, err := rtree_db.Nearest(ctx, &pt, 1)
+
+	if err != nil {
+		t.Fatalf("Failed to perform nearest query, %v", err)
+	}
+
+	if len(nearest) != 1 || nearest[0].Place.Id() != "2" {
+		t.Fatalf("Expected line 2 to be the nearest feature")
+	}
+
+	expected_distance := haversineDistance(pt, orb.Point{2.5, 2})
+
+	if nearest[0].Distance-expected_distance > 1.0 {
+		t.Fatalf("Expected distance of %f but got %f", expected_distance, nearest[0].Distance)
+	}
+
+	within, err := rtree_db.WithinDistance(ctx, &pt, 250000)
+
+	if err != nil {
+		t.Fatalf("Failed to perform within distance query, %v", err)
+	}
+
+	if len(within) != 2 {
+		t.Fatalf("Expected 2 features within 250km but got %d", len(within))
+	}
+
+	pip, err := rtree_db.PointInPolygon(ctx, &orb.Point{1, 1})
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(pip.Results()) != 0 {
+		t.Fatalf("Expected points to be excluded from point in polygon results")
+	}
+}
@@ -0,0 +1,55 @@
+package rtree
+
+import (
+	"context"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// Intersects returns the SPR records for all the features whose geometry intersects 'b'. Candidates are
+// derived from the rtree and then tested against their actual geometries, rather than their bounding boxes.
+// If the minimum longitude of 'b' is greater than its maximum longitude then 'b' is assumed to cross the
+// antimeridian.
+func (r *RTreeSpatialDatabase) Intersects(ctx context.Context, b orb.Bound, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	query_func := func(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool) {
+		r.IntersectsWithChannels(ctx, rsp_ch, err_ch, done_ch, b, filters...)
+	}
+
+	return r.collectResults(ctx, query_func)
+}
+
+// IntersectsWithChannels dispatches the SPR records for all the features whose geometry intersects 'b'
+// to 'rsp_ch'. Errors are dispatched to 'err_ch' and 'done_ch' is notified when the query is complete.
+func (r *RTreeSpatialDatabase) IntersectsWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool, b orb.Bound, filters ...spatial.Filter) {
+
+	defer sendDone(ctx, done_ch)
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	rows, err := r.getIntersectsByBound(g, b)
+
+	if err != nil {
+		sendError(ctx, err_ch, err)
+		return
+	}
+
+	bounds := splitBound(b)
+
+	intersects_func := func(orb_geom orb.Geometry) bool {
+
+		for _, sb := range bounds {
+
+			if geometryIntersectsBound(orb_geom, sb) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	r.inflateResultsWithChannels(ctx, g, rsp_ch, err_ch, rows, intersects_func, true, filters...)
+}
@@ -0,0 +1,106 @@
+package rtree
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestSpatialDatabaseIntersects(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	err = database.IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/microhoods")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	// Old Cambridge
+
+	b := orb.Bound{
+		Min: orb.Point{-71.1205, 42.3758},
+		Max: orb.Point{-71.1198, 42.3762},
+	}
+
+	rsp, err := rtree_db.Intersects(ctx, b)
+
+	if err != nil {
+		t.Fatalf("Failed to perform intersects query, %v", err)
+	}
+
+	ids := make([]string, 0)
+
+	for _, s := range rsp.Results() {
+		ids = append(ids, s.Id())
+	}
+
+	if !slices.Contains(ids, "1108712253") {
+		t.Fatalf("Expected results to contain 1108712253, got %v", ids)
+	}
+}
+
+func TestSpatialDatabaseIntersectsExact(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	body, err := os.ReadFile("fixtures/microhoods/1108712253.geojson")
+
+	if err != nil {
+		t.Fatalf("Failed to read fixture, %v", err)
+	}
+
+	// An L-shaped polygon whose bounding box covers the top-right corner but whose geometry does not
+
+	l_shape := orb.Polygon{
+		orb.Ring{{0, 0}, {10, 0}, {10, 2}, {2, 2}, {2, 10}, {0, 10}, {0, 0}},
+	}
+
+	err = db.IndexFeature(ctx, featureWithGeometry(t, body, l_shape))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	tests := map[orb.Bound]int{
+		orb.Bound{Min: orb.Point{8, 8}, Max: orb.Point{9, 9}}:     0,
+		orb.Bound{Min: orb.Point{8, 1}, Max: orb.Point{9, 3}}:     1,
+		orb.Bound{Min: orb.Point{-1, -1}, Max: orb.Point{11, 11}}: 1,
+		orb.Bound{Min: orb.Point{0.5, 0.5}, Max: orb.Point{1, 1}}: 1,
+	}
+
+	for b, expected := range tests {
+
+		rsp, err := rtree_db.Intersects(ctx, b)
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		count := len(rsp.Results())
+
+		if count != expected {
+			t.Fatalf("Expected %d results for %v but got %d", expected, b, count)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-whosonfirst-feature/geometry"
+	"github.com/whosonfirst/go-whosonfirst-uri"
+)
+
+// If the database was created with a `geometry_reader_uri` parameter then geometries are not stored in the
+// cache. Instead they are read on demand from the geometry reader, using the relative WOF path for each feature
+// (for example "101/736/545/101736545.geojson"), and optionally kept in a bounded "hot" cache of recently used
+// geometries. Each generation has its own geometry cache and cached geometries are removed whenever a feature is
+// re-indexed or removed.
+
+// newGeometryCache returns a new geometry cache keeping at most 'size' geometries or nil if 'size' is zero.
+func newGeometryCache(size int) featureCache {
+
+	if size == 0 {
+		return nil
+	}
+
+	return newLRUCache(size, nil)
+}
+
+// retrieveGeometry returns the geometry for 'sp' using 'cache_item' if it has a geometry (or an encoded geometry)
+// or the geometry reader (and the geometry cache for 'g') otherwise.
+func (r *RTreeSpatialDatabase) retrieveGeometry(ctx context.Context, g *generation, sp *RTreeSpatialIndex, cache_item *RTreeCache) (orb.Geometry, error) {
+
+	if cache_item.Geometry != nil {
+		return cache_item.Geometry.Geometry(), nil
+	}
+
+	if cache_item.EncodedGeometry != nil {
+		return decodeGeometry(cache_item.EncodedGeometry, cache_item.GeometryEncoding)
+	}
+
+	if r.geometry_reader == nil {
+		return nil, fmt.Errorf("Cache item for %s has no geometry and there is no geometry reader", sp.FeatureId)
+	}
+
+	cache_key := cacheKey(sp.FeatureId, sp.AltLabel)
+
+	if g.geometry_cache != nil {
+
+		geom_item, err := g.geometry_cache.Get(ctx, cache_key)
+
+		if err == nil {
+			return geom_item.Geometry.Geometry(), nil
+		}
+	}
+
+	rel_path, err := geometryPath(sp.FeatureId, sp.AltLabel)
+
+	if err != nil {
+		return nil, err
+	}
+
+	fh, err := r.geometry_reader.Read(ctx, rel_path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read %s, %w", rel_path, err)
+	}
+
+	defer fh.Close()
+
+	body, err := io.ReadAll(fh)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read body for %s, %w", rel_path, err)
+	}
+
+	geom, err := geometry.Geometry(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to derive geometry for %s, %w", rel_path, err)
+	}
+
+	if g.geometry_cache != nil {
+
+		err = r.cacheGeometry(ctx, g, sp, geom)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to store geometry for %s, %w", rel_path, err)
+		}
+	}
+
+	return geom.Geometry(), nil
+}
+
+// cacheGeometry stores 'geom' in the geometry cache for 'g' if 'sp' is still indexed. The feature may have been
+// re-indexed, or removed, while its geometry was being read in which case 'geom' may be out of date. The read lock
+// is held so that the feature can not be re-indexed, and its cached geometry removed, in between checking and
+// storing the geometry.
+func (r *RTreeSpatialDatabase) cacheGeometry(ctx context.Context, g *generation, sp *RTreeSpatialIndex, geom *geojson.Geometry) error {
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if !g.isIndexed(sp) {
+		return nil
+	}
+
+	return g.geometry_cache.Set(ctx, cacheKey(sp.FeatureId, sp.AltLabel), &RTreeCache{Geometry: geom})
+}
+
+// geometryPath returns the relative WOF path for 'feature_id' and 'alt_label'.
+func geometryPath(feature_id string, alt_label string) (string, error) {
+
+	id, err := strconv.ParseInt(feature_id, 10, 64)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to parse ID %s, %w", feature_id, err)
+	}
+
+	uri_args := uri.NewDefaultURIArgs()
+
+	if alt_label != "" {
+
+		args, err := uri.NewAlternateURIArgsFromAltLabel(alt_label)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to derive URI arguments for alt label %s, %w", alt_label, err)
+		}
+
+		uri_args = args
+	}
+
+	rel_path, err := uri.Id2RelPath(id, uri_args)
+
+	if err != nil {
+		return "", fmt.Errorf("Failed to derive path for %s, %w", feature_id, err)
+	}
+
+	return rel_path, nil
+}
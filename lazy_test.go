@@ -0,0 +1,270 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestSpatialDatabaseLazyGeometries(t *testing.T) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	features := map[int64]orb.Bound{
+		1: {Min: orb.Point{0, 0}, Max: orb.Point{1, 1}},
+		2: {Min: orb.Point{5, 5}, Max: orb.Point{6, 6}},
+	}
+
+	bodies := make(map[int64][]byte)
+
+	for id, b := range features {
+
+		body := newTestFeature(t, id, b.ToPolygon())
+		bodies[id] = body
+
+		// Only write feature 1 to the geometry reader's root
+
+		if id != 1 {
+			continue
+		}
+
+		rel_path, err := geometryPath(fmt.Sprintf("%d", id), "")
+
+		if err != nil {
+			t.Fatalf("Failed to derive path for %d, %v", id, err)
+		}
+
+		path := filepath.Join(root, rel_path)
+
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+
+		if err != nil {
+			t.Fatalf("Failed to create parent for %s, %v", path, err)
+		}
+
+		err = os.WriteFile(path, body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+	}
+
+	reader_uri := fmt.Sprintf("fs://%s", root)
+
+	for _, database_uri := range []string{
+		fmt.Sprintf("rtree://?geometry_reader_uri=%s", url.QueryEscape(reader_uri)),
+		fmt.Sprintf("rtree://?geometry_reader_uri=%s&geometry_cache_size=1", url.QueryEscape(reader_uri)),
+	} {
+
+		db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		for _, body := range bodies {
+
+			err := db.IndexFeature(ctx, body)
+
+			if err != nil {
+				t.Fatalf("Failed to index feature for %s, %v", database_uri, err)
+			}
+		}
+
+		cache_item, err := db.(*RTreeSpatialDatabase).current.Load().cache.Get(ctx, cacheKey("1", ""))
+
+		if err != nil {
+			t.Fatalf("Failed to retrieve cache item for %s, %v", database_uri, err)
+		}
+
+		if cache_item.Geometry != nil {
+			t.Fatalf("Expected cache item for %s not to have a geometry", database_uri)
+		}
+
+		// Query twice to exercise the geometry cache
+
+		for i := 0; i < 2; i++ {
+
+			c := orb.Point{0.5, 0.5}
+
+			spr, err := db.PointInPolygon(ctx, &c)
+
+			if err != nil {
+				t.Fatalf("Failed to perform point in polygon query for %s, %v", database_uri, err)
+			}
+
+			if len(spr.Results()) != 1 {
+				t.Fatalf("Expected 1 result for %s but got %d", database_uri, len(spr.Results()))
+			}
+		}
+
+		// Feature 2 is indexed but its geometry can not be read so queries which need it fail
+
+		c := orb.Point{5.5, 5.5}
+
+		_, err = db.PointInPolygon(ctx, &c)
+
+		if err == nil {
+			t.Fatalf("Expected point in polygon query for %s to fail", database_uri)
+		}
+
+		r, err := db.Read(ctx, "1.geojson")
+
+		if err != nil {
+			t.Fatalf("Failed to read feature for %s, %v", database_uri, err)
+		}
+
+		body, err := io.ReadAll(r)
+		r.Close()
+
+		if err != nil {
+			t.Fatalf("Failed to read body for %s, %v", database_uri, err)
+		}
+
+		f, err := geojson.UnmarshalFeature(body)
+
+		if err != nil {
+			t.Fatalf("Failed to unmarshal feature for %s, %v", database_uri, err)
+		}
+
+		if !f.Geometry.Bound().Equal(features[1]) {
+			t.Fatalf("Unexpected geometry for %s, %v", database_uri, f.Geometry.Bound())
+		}
+	}
+}
+
+func TestSpatialDatabaseLazyGeometriesReindex(t *testing.T) {
+
+	ctx := context.Background()
+
+	root := t.TempDir()
+
+	rel_path, err := geometryPath("1", "")
+
+	if err != nil {
+		t.Fatalf("Failed to derive path, %v", err)
+	}
+
+	path := filepath.Join(root, rel_path)
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+
+	if err != nil {
+		t.Fatalf("Failed to create parent for %s, %v", path, err)
+	}
+
+	// writeFeature writes feature 1, as a one degree square whose minimum corner is 'x,x', to the geometry
+	// reader's root and returns its body
+
+	writeFeature := func(x float64) []byte {
+
+		b := orb.Bound{Min: orb.Point{x, x}, Max: orb.Point{x + 1, x + 1}}
+		body := newTestFeature(t, 1, b.ToPolygon())
+
+		err := os.WriteFile(path, body, 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", path, err)
+		}
+
+		return body
+	}
+
+	reader_uri := fmt.Sprintf("fs://%s", root)
+	database_uri := fmt.Sprintf("rtree://?geometry_reader_uri=%s&geometry_cache_size=10", url.QueryEscape(reader_uri))
+
+	db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	assertCount := func(x float64, expected int) {
+
+		c := orb.Point{x + 0.5, x + 0.5}
+
+		spr, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query at %v, %v", c, err)
+		}
+
+		if len(spr.Results()) != expected {
+			t.Fatalf("Expected %d results at %v but got %d", expected, c, len(spr.Results()))
+		}
+	}
+
+	err = db.IndexFeature(ctx, writeFeature(0))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	// Cache the geometry and then move the feature
+
+	assertCount(0, 1)
+
+	err = db.IndexFeature(ctx, writeFeature(10))
+
+	if err != nil {
+		t.Fatalf("Failed to re-index feature, %v", err)
+	}
+
+	assertCount(10, 1)
+	assertCount(0, 0)
+
+	// Remove the feature and index it again somewhere else
+
+	err = db.RemoveFeature(ctx, "1")
+
+	if err != nil {
+		t.Fatalf("Failed to remove feature, %v", err)
+	}
+
+	err = db.IndexFeature(ctx, writeFeature(20))
+
+	if err != nil {
+		t.Fatalf("Failed to index feature, %v", err)
+	}
+
+	assertCount(20, 1)
+
+	// Bulk-load the feature somewhere else
+
+	err = rtree_db.IndexFeatures(ctx, writeFeature(30))
+
+	if err != nil {
+		t.Fatalf("Failed to index features, %v", err)
+	}
+
+	assertCount(30, 1)
+
+	// Move the feature and rebuild the index
+
+	writeFeature(40)
+
+	err = rtree_db.Rebuild(ctx, "directory://", root)
+
+	if err != nil {
+		t.Fatalf("Failed to rebuild index, %v", err)
+	}
+
+	assertCount(40, 1)
+	assertCount(30, 0)
+}
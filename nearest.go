@@ -0,0 +1,199 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/dhconnelly/rtreego"
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// RTreeDistanceResult is a SPR record and its distance, in meters, from a query coordinate.
+type RTreeDistanceResult struct {
+	Place    spr.StandardPlacesResult `json:"place"`
+	Distance float64                  `json:"distance"`
+}
+
+// Nearest returns the 'k' features closest to 'coord' ordered by the distance, in meters, between 'coord'
+// and each feature's geometry. Features whose geometry contains 'coord' have a distance of zero.
+func (r *RTreeSpatialDatabase) Nearest(ctx context.Context, coord *orb.Point, k int, filters ...spatial.Filter) ([]*RTreeDistanceResult, error) {
+
+	if k < 1 {
+		return nil, fmt.Errorf("Invalid number of results, %d", k)
+	}
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	// First find the k closest features by bounding box. These are not necessarily the closest features
+	// by geometry but the farthest of them is an upper bound on the distance to the k-th closest feature.
+
+	// The filter function can not return an error so the first error is recorded and returned once the
+	// search is complete
+
+	var nn_err error
+
+	nn_filter := func(results []rtreego.Spatial, obj rtreego.Spatial) (bool, bool) {
+
+		sp := obj.(*RTreeSpatialIndex)
+
+		for _, other := range results {
+
+			other_sp := other.(*RTreeSpatialIndex)
+
+			if other_sp.FeatureId == sp.FeatureId && other_sp.AltLabel == sp.AltLabel {
+				return true, false
+			}
+		}
+
+		// The read lock is held while this function is called so it must not call isIndexed (or
+		// candidateCacheItem) but, for the same reason, every entry in the rtree has a cache item
+
+		cache_item, err := g.retrieveCache(ctx, sp)
+
+		if err != nil {
+
+			if nn_err == nil {
+				nn_err = fmt.Errorf("Failed to retrieve cache item for %s, %w", sp.Id, err)
+			}
+
+			return true, true
+		}
+
+		return !matchesFilters(cache_item.SPR, filters...), false
+	}
+
+	pt := rtreego.Point{coord.X(), coord.Y()}
+
+	r.mu.RLock()
+	rows := g.rtree.NearestNeighbors(k, pt, nn_filter)
+	r.mu.RUnlock()
+
+	if nn_err != nil {
+		return nil, nn_err
+	}
+
+	results, err := r.distanceResults(ctx, g, coord, rows, filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) < k {
+		return results, nil
+	}
+
+	max_distance := results[len(results)-1].Distance
+
+	if max_distance == 0.0 {
+		return results[0:k], nil
+	}
+
+	// Now find every feature whose bounding box is within that distance and sort them by their actual distance
+
+	b := boundWithRadius(*coord, max_distance)
+
+	rows, err = r.getIntersectsByBound(g, b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results, err = r.distanceResults(ctx, g, coord, rows, filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) > k {
+		results = results[0:k]
+	}
+
+	return results, nil
+}
+
+// distanceResults returns a list of `RTreeDistanceResult` instances, sorted by distance, for the unique
+// features in 'possible' that match 'filters'.
+func (r *RTreeSpatialDatabase) distanceResults(ctx context.Context, g *generation, coord *orb.Point, possible []rtreego.Spatial, filters ...spatial.Filter) ([]*RTreeDistanceResult, error) {
+
+	seen := make(map[string]bool)
+	results := make([]*RTreeDistanceResult, 0)
+
+	for _, row := range possible {
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+			// pass
+		}
+
+		sp := row.(*RTreeSpatialIndex)
+		cache_key := cacheKey(sp.FeatureId, sp.AltLabel)
+
+		if seen[cache_key] {
+			continue
+		}
+
+		seen[cache_key] = true
+
+		cache_item, err := r.candidateCacheItem(ctx, g, sp)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if cache_item == nil {
+			continue
+		}
+
+		if !matchesFilters(cache_item.SPR, filters...) {
+			continue
+		}
+
+		orb_geom, err := r.retrieveGeometry(ctx, g, sp, cache_item)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to retrieve geometry for %s, %w", sp.Id, err)
+		}
+
+		d := distanceToGeometry(*coord, orb_geom)
+
+		rsp := &RTreeDistanceResult{
+			Place:    cache_item.SPR,
+			Distance: d,
+		}
+
+		results = append(results, rsp)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+
+		if results[i].Distance != results[j].Distance {
+			return results[i].Distance < results[j].Distance
+		}
+
+		return results[i].Place.Id() < results[j].Place.Id()
+	})
+
+	return results, nil
+}
+
+// matchesFilters returns a boolean value indicating whether 's' satisfies all of 'filters'.
+func matchesFilters(s spr.StandardPlacesResult, filters ...spatial.Filter) bool {
+
+	for _, f := range filters {
+
+		err := filter.FilterSPR(f, s)
+
+		if err != nil {
+			return false
+		}
+	}
+
+	return true
+}
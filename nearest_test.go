@@ -0,0 +1,93 @@
+package rtree
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestSpatialDatabaseNearest(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	features := map[int64]orb.Polygon{
+		// A box whose closest corner is about 157km from the origin
+		1: orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}.ToPolygon(),
+		// A triangle whose bounding box is very close to the origin but whose geometry is about 400km away
+		2: orb.Polygon{orb.Ring{{5, 0.1}, {5, 5}, {0.1, 5}, {5, 0.1}}},
+		// A box whose closest corner is about 550km from the origin
+		3: orb.Bound{Min: orb.Point{-4, -4}, Max: orb.Point{-3.5, -3.5}}.ToPolygon(),
+	}
+
+	for id, poly := range features {
+
+		err := db.IndexFeature(ctx, newTestFeature(t, id, poly))
+
+		if err != nil {
+			t.Fatalf("Failed to index feature %d, %v", id, err)
+		}
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	origin := orb.Point{0, 0}
+
+	tests := map[int][]string{
+		1: []string{"1"},
+		2: []string{"1", "2"},
+		3: []string{"1", "2", "3"},
+		4: []string{"1", "2", "3"},
+	}
+
+	for k, expected := range tests {
+
+		results, err := rtree_db.Nearest(ctx, &origin, k)
+
+		if err != nil {
+			t.Fatalf("Failed to perform nearest query, %v", err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, rsp := range results {
+			ids = append(ids, rsp.Place.Id())
+		}
+
+		if !slices.Equal(ids, expected) {
+			t.Fatalf("Expected %v for k=%d but got %v", expected, k, ids)
+		}
+	}
+
+	results, err := rtree_db.Nearest(ctx, &origin, 1)
+
+	if err != nil {
+		t.Fatalf("Failed to perform nearest query, %v", err)
+	}
+
+	expected_distance := haversineDistance(origin, orb.Point{1, 1})
+
+	if math.Abs(results[0].Distance-expected_distance) > 1.0 {
+		t.Fatalf("Expected distance of %f but got %f", expected_distance, results[0].Distance)
+	}
+
+	inside := orb.Point{1.5, 1.5}
+
+	results, err = rtree_db.Nearest(ctx, &inside, 1)
+
+	if err != nil {
+		t.Fatalf("Failed to perform nearest query, %v", err)
+	}
+
+	if results[0].Place.Id() != "1" || results[0].Distance != 0.0 {
+		t.Fatalf("Expected 1 with a distance of 0 but got %s (%f)", results[0].Place.Id(), results[0].Distance)
+	}
+}
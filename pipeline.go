@@ -0,0 +1,333 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/whosonfirst/go-whosonfirst-iterate/v2/iterator"
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+// The default interval at which progress is reported by `IndexDatabaseWithPipeline`.
+const defaultProgressInterval time.Duration = 10 * time.Second
+
+// IndexPipelineOptions defines configuration options for `IndexDatabaseWithPipeline`.
+type IndexPipelineOptions struct {
+	// The number of workers used to parse and index records. If zero then `runtime.NumCPU` is used.
+	Workers int
+	// An optional function to be called with the current progress every `ProgressInterval` and once indexing
+	// is complete. If nil then progress is logged using the default `slog` logger.
+	Progress IndexProgressFunc
+	// The interval at which progress is reported. If zero then progress is reported every 10 seconds.
+	ProgressInterval time.Duration
+	// If true then records are parsed by the workers and buffered in memory and, once all the records have been
+	// read, the rtree is bulk-loaded once (see `RTreeSpatialDatabase.IndexFeatures`) which produces a better packed
+	// tree. Bulk loading is only supported by `RTreeSpatialDatabase` instances.
+	Bulk bool
+}
+
+// IndexProgress is a snapshot of the progress of `IndexDatabaseWithPipeline`.
+type IndexProgress struct {
+	// The number of records (files) that have been processed.
+	Processed int64
+	// The number of features that have been indexed. In bulk mode this is the number of features that have been
+	// parsed and are waiting to be loaded.
+	Indexed int64
+	// The number of records (files) that failed to be indexed.
+	Failed int64
+	// The amount of time since indexing started.
+	Elapsed time.Duration
+}
+
+// Rate returns the number of records processed per second.
+func (p *IndexProgress) Rate() float64 {
+
+	if p.Elapsed <= 0 {
+		return 0.0
+	}
+
+	return float64(p.Processed) / p.Elapsed.Seconds()
+}
+
+// IndexProgressFunc is a function that is called with the current progress of `IndexDatabaseWithPipeline`.
+type IndexProgressFunc func(context.Context, *IndexProgress)
+
+// IndexFailure records a record (file) that failed to be indexed and why.
+type IndexFailure struct {
+	Path  string
+	Error error
+}
+
+// IndexSummary is the summary of a call to `IndexDatabaseWithPipeline`.
+type IndexSummary struct {
+	IndexProgress
+	// The list of records (files) that failed to be indexed.
+	Failures []*IndexFailure
+}
+
+// indexJob is a record (file) read by the iterator waiting to be indexed.
+type indexJob struct {
+	Path string
+	Body []byte
+}
+
+// IndexDatabaseWithPipeline indexes 'db' with the records emitted by a whosonfirst/go-whosonfirst-iterate/v2
+// iterator, parsing and indexing records across a pool of workers. Records of all geometry types are indexed.
+// Unlike `IndexDatabaseWithIterator` a record that fails to be indexed does not stop indexing; instead each
+// failure is recorded in the `IndexSummary` that is returned. An error is only returned if the iterator itself
+// fails, 'ctx' is cancelled or, in bulk mode, the buffered records fail to be loaded, in which case the summary for
+// the records processed so far is also returned. If 'opts' is nil then the default options are used. In bulk mode
+// (see `IndexPipelineOptions.Bulk`) nothing is loaded in to the database if the iterator fails or 'ctx' is cancelled.
+func IndexDatabaseWithPipeline(ctx context.Context, db database.SpatialDatabase, opts *IndexPipelineOptions, iterator_uri string, iterator_sources ...string) (*IndexSummary, error) {
+
+	if opts == nil {
+		opts = &IndexPipelineOptions{}
+	}
+
+	rtree_db, is_rtree := db.(*RTreeSpatialDatabase)
+
+	if opts.Bulk && !is_rtree {
+		return nil, fmt.Errorf("Bulk loading is only supported by RTreeSpatialDatabase, not %T", db)
+	}
+
+	// In bulk mode the records parsed by the workers are buffered here and loaded once indexing is complete
+
+	records := make([]*indexRecord, 0)
+	records_mu := new(sync.Mutex)
+
+	index_func := func(ctx context.Context, body []byte) (int, error) {
+
+		if !opts.Bulk {
+			return indexRecordBody(ctx, db, body)
+		}
+
+		body_records, err := rtree_db.recordBodyIndexRecords(ctx, body)
+
+		records_mu.Lock()
+		records = append(records, body_records...)
+		records_mu.Unlock()
+
+		return len(body_records), err
+	}
+
+	workers := opts.Workers
+
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	interval := opts.ProgressInterval
+
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+
+	progress_func := opts.Progress
+
+	if progress_func == nil {
+		progress_func = logProgress
+	}
+
+	var processed int64
+	var indexed int64
+	var failed int64
+
+	failures := make([]*IndexFailure, 0)
+	failures_mu := new(sync.Mutex)
+
+	t1 := time.Now()
+
+	progress := func() *IndexProgress {
+
+		return &IndexProgress{
+			Processed: atomic.LoadInt64(&processed),
+			Indexed:   atomic.LoadInt64(&indexed),
+			Failed:    atomic.LoadInt64(&failed),
+			Elapsed:   time.Since(t1),
+		}
+	}
+
+	summary := func() *IndexSummary {
+
+		failures_mu.Lock()
+		defer failures_mu.Unlock()
+
+		return &IndexSummary{
+			IndexProgress: *progress(),
+			Failures:      failures,
+		}
+	}
+
+	jobs := make(chan *indexJob, workers)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < workers; i++ {
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for job := range jobs {
+
+				count, err := index_func(ctx, job.Body)
+
+				atomic.AddInt64(&indexed, int64(count))
+				atomic.AddInt64(&processed, 1)
+
+				if err != nil {
+
+					atomic.AddInt64(&failed, 1)
+
+					failures_mu.Lock()
+					failures = append(failures, &IndexFailure{Path: job.Path, Error: err})
+					failures_mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	done_ch := make(chan bool)
+	progress_wg := new(sync.WaitGroup)
+
+	progress_wg.Add(1)
+
+	go func() {
+
+		defer progress_wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done_ch:
+				return
+			case <-ticker.C:
+				progress_func(ctx, progress())
+			}
+		}
+	}()
+
+	iter_cb := func(ctx context.Context, path string, r io.ReadSeeker, args ...interface{}) error {
+
+		body, err := io.ReadAll(r)
+
+		if err != nil {
+
+			atomic.AddInt64(&processed, 1)
+			atomic.AddInt64(&failed, 1)
+
+			failures_mu.Lock()
+			failures = append(failures, &IndexFailure{Path: path, Error: fmt.Errorf("Failed to read document, %w", err)})
+			failures_mu.Unlock()
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case jobs <- &indexJob{Path: path, Body: body}:
+			return nil
+		}
+	}
+
+	iter_err := func() error {
+
+		iter, err := iterator.NewIterator(ctx, iterator_uri, iter_cb)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create iterator, %w", err)
+		}
+
+		err = iter.IterateURIs(ctx, iterator_sources...)
+
+		if err != nil {
+			return fmt.Errorf("Failed to iterate URIs, %w", err)
+		}
+
+		return nil
+	}()
+
+	close(jobs)
+	wg.Wait()
+
+	var load_err error
+
+	if opts.Bulk && iter_err == nil && ctx.Err() == nil {
+
+		load_err = rtree_db.loadIndexRecords(ctx, records)
+
+		if load_err != nil {
+			load_err = fmt.Errorf("Failed to load records, %w", load_err)
+		}
+	}
+
+	close(done_ch)
+	progress_wg.Wait()
+
+	progress_func(ctx, progress())
+
+	if iter_err != nil {
+		return summary(), iter_err
+	}
+
+	if load_err != nil {
+		return summary(), load_err
+	}
+
+	err := ctx.Err()
+
+	if err != nil {
+		return summary(), err
+	}
+
+	return summary(), nil
+}
+
+// indexRecordBody indexes the GeoJSON Feature, or FeatureCollection, in 'body' and returns the number of
+// features that were indexed.
+func indexRecordBody(ctx context.Context, db database.SpatialDatabase, body []byte) (int, error) {
+
+	feature_bodies, err := featureBodies(body)
+
+	if err != nil {
+		return 0, err
+	}
+
+	for i, f_body := range feature_bodies {
+
+		select {
+		case <-ctx.Done():
+			return i, ctx.Err()
+		default:
+			// pass
+		}
+
+		err := db.IndexFeature(ctx, f_body)
+
+		if err != nil {
+
+			if len(feature_bodies) == 1 {
+				return i, err
+			}
+
+			return i, fmt.Errorf("Failed to index Feature at offset %d in record, %w", i, err)
+		}
+	}
+
+	return len(feature_bodies), nil
+}
+
+// logProgress is the default `IndexProgressFunc` which logs 'p' using the default `slog` logger.
+func logProgress(ctx context.Context, p *IndexProgress) {
+	slog.Info("Indexing progress", "processed", p.Processed, "indexed", p.Indexed, "failed", p.Failed, "elapsed", p.Elapsed, "rate", fmt.Sprintf("%.2f/s", p.Rate()))
+}
@@ -0,0 +1,146 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestIndexDatabaseWithPipeline(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, bulk := range []bool{false, true} {
+
+		db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database, %v", err)
+		}
+
+		defer db.Close(ctx)
+
+		root := t.TempDir()
+
+		for id, b := range map[int64]orb.Bound{
+			1: {Min: orb.Point{0, 0}, Max: orb.Point{1, 1}},
+			2: {Min: orb.Point{5, 5}, Max: orb.Point{6, 6}},
+		} {
+
+			path := filepath.Join(root, fmt.Sprintf("%d.geojson", id))
+
+			err := os.WriteFile(path, newTestFeature(t, id, b.ToPolygon()), 0644)
+
+			if err != nil {
+				t.Fatalf("Failed to write %s, %v", path, err)
+			}
+		}
+
+		invalid_path := filepath.Join(root, "invalid.geojson")
+
+		err = os.WriteFile(invalid_path, []byte(`{"type":"Feature"}`), 0644)
+
+		if err != nil {
+			t.Fatalf("Failed to write %s, %v", invalid_path, err)
+		}
+
+		var calls int32
+
+		opts := &IndexPipelineOptions{
+			Workers: 2,
+			Bulk:    bulk,
+			Progress: func(ctx context.Context, p *IndexProgress) {
+				atomic.AddInt32(&calls, 1)
+			},
+		}
+
+		summary, err := IndexDatabaseWithPipeline(ctx, db, opts, "directory://", root)
+
+		if err != nil {
+			t.Fatalf("Failed to index database, %v", err)
+		}
+
+		if atomic.LoadInt32(&calls) == 0 {
+			t.Fatalf("Expected progress function to be called")
+		}
+
+		if summary.Processed != 3 || summary.Indexed != 2 || summary.Failed != 1 {
+			t.Fatalf("Unexpected summary %v with bulk=%t", summary.IndexProgress, bulk)
+		}
+
+		if len(summary.Failures) != 1 || summary.Failures[0].Path != invalid_path || summary.Failures[0].Error == nil {
+			t.Fatalf("Expected a single failure for %s but got %v", invalid_path, summary.Failures)
+		}
+
+		for _, c := range []orb.Point{{0.5, 0.5}, {5.5, 5.5}} {
+
+			spr, err := db.PointInPolygon(ctx, &c)
+
+			if err != nil {
+				t.Fatalf("Failed to perform point in polygon query, %v", err)
+			}
+
+			if len(spr.Results()) != 1 {
+				t.Fatalf("Expected 1 result at %v with bulk=%t but got %d", c, bulk, len(spr.Results()))
+			}
+		}
+	}
+}
+
+// BenchmarkIndexDatabaseWithPipeline compares indexing the features in fixtures/microhoods one at a time
+// with buffering them and bulk-loading the rtree once. To measure a different dataset, for example a full repo,
+// set the RTREE_BENCHMARK_ITERATOR_URI and RTREE_BENCHMARK_ITERATOR_SOURCE environment variables.
+func BenchmarkIndexDatabaseWithPipeline(b *testing.B) {
+
+	ctx := context.Background()
+
+	iterator_uri := `directory://?_exclude=\.go$`
+	iterator_source := "fixtures/microhoods"
+
+	if v := os.Getenv("RTREE_BENCHMARK_ITERATOR_URI"); v != "" {
+		iterator_uri = v
+	}
+
+	if v := os.Getenv("RTREE_BENCHMARK_ITERATOR_SOURCE"); v != "" {
+		iterator_source = v
+	}
+
+	quiet := func(ctx context.Context, p *IndexProgress) {}
+
+	for _, bulk := range []bool{false, true} {
+
+		b.Run(fmt.Sprintf("bulk=%t", bulk), func(b *testing.B) {
+
+			for i := 0; i < b.N; i++ {
+
+				db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+				if err != nil {
+					b.Fatalf("Failed to create new spatial database, %v", err)
+				}
+
+				opts := &IndexPipelineOptions{
+					Bulk:     bulk,
+					Progress: quiet,
+				}
+
+				summary, err := IndexDatabaseWithPipeline(ctx, db, opts, iterator_uri, iterator_source)
+
+				if err != nil {
+					b.Fatalf("Failed to index database, %v", err)
+				}
+
+				if summary.Failed != 0 {
+					b.Fatalf("Failed to index %d records", summary.Failed)
+				}
+
+				db.Close(ctx)
+			}
+		})
+	}
+}
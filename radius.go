@@ -0,0 +1,48 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+)
+
+// WithinDistance returns all the features whose geometry is within 'meters' of 'coord' ordered by their
+// distance, in meters, from 'coord'. Features whose geometry contains 'coord' have a distance of zero.
+func (r *RTreeSpatialDatabase) WithinDistance(ctx context.Context, coord *orb.Point, meters float64, filters ...spatial.Filter) ([]*RTreeDistanceResult, error) {
+
+	if meters <= 0.0 {
+		return nil, fmt.Errorf("Invalid distance, %f", meters)
+	}
+
+	b := boundWithRadius(*coord, meters)
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	rows, err := r.getIntersectsByBound(g, b)
+
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := r.distanceResults(ctx, g, coord, rows, filters...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*RTreeDistanceResult, 0)
+
+	for _, rsp := range candidates {
+
+		if rsp.Distance > meters {
+			break
+		}
+
+		results = append(results, rsp)
+	}
+
+	return results, nil
+}
@@ -0,0 +1,76 @@
+package rtree
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestSpatialDatabaseWithinDistance(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	features := map[int64]orb.Polygon{
+		// A box whose closest corner is about 157km from the point
+		1: orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{2, 2}}.ToPolygon(),
+		// A triangle whose bounding box is very close to the point but whose geometry is about 400km away
+		2: orb.Polygon{orb.Ring{{5, 0.1}, {5, 5}, {0.1, 5}, {5, 0.1}}},
+		// A box at high latitude, about 1 degree of longitude (roughly 19km) east of the point
+		3: orb.Bound{Min: orb.Point{1, 80}, Max: orb.Point{2, 81}}.ToPolygon(),
+	}
+
+	for id, poly := range features {
+
+		err := db.IndexFeature(ctx, newTestFeature(t, id, poly))
+
+		if err != nil {
+			t.Fatalf("Failed to index feature %d, %v", id, err)
+		}
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	tests := []struct {
+		Point    orb.Point
+		Meters   float64
+		Expected []string
+	}{
+		{orb.Point{0, 0}, 10000, []string{}},
+		{orb.Point{0, 0}, 200000, []string{"1"}},
+		{orb.Point{0, 0}, 450000, []string{"1", "2"}},
+		{orb.Point{0, 80.5}, 15000, []string{}},
+		{orb.Point{0, 80.5}, 25000, []string{"3"}},
+	}
+
+	for _, test := range tests {
+
+		results, err := rtree_db.WithinDistance(ctx, &test.Point, test.Meters)
+
+		if err != nil {
+			t.Fatalf("Failed to perform within distance query, %v", err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, rsp := range results {
+
+			if rsp.Distance > test.Meters {
+				t.Fatalf("Result %s is %f meters away which is more than %f", rsp.Place.Id(), rsp.Distance, test.Meters)
+			}
+
+			ids = append(ids, rsp.Place.Id())
+		}
+
+		if !slices.Equal(ids, test.Expected) {
+			t.Fatalf("Expected %v within %f meters of %v but got %v", test.Expected, test.Meters, test.Point, ids)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package rtree
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// RawBody returns the original (uncompressed) feature body for 'c' or an error if the body was not stored.
+func (c *RTreeCache) RawBody() ([]byte, error) {
+
+	if c.Body == nil {
+		return nil, fmt.Errorf("Feature body was not stored")
+	}
+
+	if !c.BodyCompressed {
+		return c.Body, nil
+	}
+
+	return decompressBody(c.Body)
+}
+
+// compressBody returns a gzip-compressed copy of 'body'.
+func compressBody(body []byte) ([]byte, error) {
+
+	var buf bytes.Buffer
+
+	gz := gzip.NewWriter(&buf)
+
+	_, err := gz.Write(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to write body, %w", err)
+	}
+
+	err = gz.Close()
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to close gzip writer, %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressBody returns the decompressed contents of 'body' which is expected to have been produced by `compressBody`.
+func decompressBody(body []byte) ([]byte, error) {
+
+	gz, err := gzip.NewReader(bytes.NewReader(body))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create gzip reader, %w", err)
+	}
+
+	defer gz.Close()
+
+	return io.ReadAll(gz)
+}
@@ -0,0 +1,82 @@
+package rtree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+)
+
+func TestSpatialDatabaseStoreRaw(t *testing.T) {
+
+	ctx := context.Background()
+
+	test_data := "fixtures/microhoods/1108712253.geojson"
+
+	body, err := os.ReadFile(test_data)
+
+	if err != nil {
+		t.Fatalf("Failed to read %s, %v", test_data, err)
+	}
+
+	for _, database_uri := range []string{
+		"rtree://?store_raw=true",
+		"rtree://?store_raw=true&compress_raw=true",
+	} {
+
+		db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		err = db.IndexFeature(ctx, body)
+
+		if err != nil {
+			t.Fatalf("Failed to index %s, %v", test_data, err)
+		}
+
+		snapshot_path := filepath.Join(t.TempDir(), "raw.snapshot")
+
+		err = db.(*RTreeSpatialDatabase).WriteSnapshotFile(ctx, snapshot_path)
+
+		if err != nil {
+			t.Fatalf("Failed to write snapshot for %s, %v", database_uri, err)
+		}
+
+		snapshot_db, err := database.NewSpatialDatabase(ctx, fmt.Sprintf("rtree://?snapshot=%s", snapshot_path))
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database from snapshot, %v", err)
+		}
+
+		defer snapshot_db.Close(ctx)
+
+		for _, reader_db := range []database.SpatialDatabase{db, snapshot_db} {
+
+			r, err := reader_db.Read(ctx, "1108712253.geojson")
+
+			if err != nil {
+				t.Fatalf("Failed to read feature for %s, %v", database_uri, err)
+			}
+
+			raw, err := io.ReadAll(r)
+			r.Close()
+
+			if err != nil {
+				t.Fatalf("Failed to read body for %s, %v", database_uri, err)
+			}
+
+			if !bytes.Equal(raw, body) {
+				t.Fatalf("Expected %s to return the original feature body", database_uri)
+			}
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+)
+
+// generationOp is a write to a generation. Writes made while a rebuild is in progress are applied to the
+// current generation and also recorded so that they can be replayed on the new generation before it is
+// swapped in.
+type generationOp func(context.Context, *generation) error
+
+// acquireGeneration returns the current generation. The generation will not be closed, even if it is replaced
+// by `Rebuild`, until the caller invokes its `release` method.
+func (r *RTreeSpatialDatabase) acquireGeneration() *generation {
+
+	for {
+
+		g := r.current.Load()
+		g.refs.Add(1)
+
+		if !g.retired.Load() {
+			return g
+		}
+
+		// The generation was replaced (and may have been closed) in between loading and acquiring it
+
+		g.release(context.Background())
+	}
+}
+
+// applyWrite applies 'op' to the current generation and, if a rebuild is in progress, records it so that it
+// can be replayed on the new generation.
+func (r *RTreeSpatialDatabase) applyWrite(ctx context.Context, op generationOp) error {
+
+	r.write_mu.Lock()
+	defer r.write_mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := op(ctx, r.current.Load())
+
+	if err != nil {
+		return err
+	}
+
+	r.recordWrite(op)
+	return nil
+}
+
+// recordWrite records 'op', if a rebuild is in progress, so that it can be replayed on the new generation. It is
+// assumed that the caller has acquired a write lock.
+func (r *RTreeSpatialDatabase) recordWrite(op generationOp) {
+
+	if r.journal != nil {
+		r.journal = append(r.journal, op)
+	}
+}
+
+// Rebuild builds a new index from the records emitted by a whosonfirst/go-whosonfirst-iterate/v2 iterator and,
+// once it is complete, replaces the current index with it. Queries are not blocked while the new index is built,
+// and continue to use the current index until the swap happens, and writes made in the meantime are replayed on
+// the new index before it is swapped in. Queries are blocked while those writes are replayed and the indexes are
+// swapped. Rebuild returns once the swap has happened. If any record fails to index
+// then an error is returned and the current index is left unchanged. Only one rebuild may run at a time.
+func (r *RTreeSpatialDatabase) Rebuild(ctx context.Context, iterator_uri string, iterator_sources ...string) error {
+
+	r.rebuild_mu.Lock()
+	defer r.rebuild_mu.Unlock()
+
+	cache, err := r.newGenerationCache(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create cache, %w", err)
+	}
+
+	// The new generation has its own, empty, geometry cache so geometries cached for the current generation
+	// are discarded when it is replaced
+
+	next := newGeneration(cache, newGeometryCache(r.geometry_cache_size), r.min_children, r.max_children)
+
+	r.mu.Lock()
+	r.journal = make([]generationOp, 0)
+	r.mu.Unlock()
+
+	abort := func(err error) error {
+
+		r.journal = nil
+		next.close(ctx)
+
+		return err
+	}
+
+	records, err := r.iteratorIndexRecords(ctx, iterator_uri, iterator_sources...)
+
+	if err == nil {
+		err = next.loadRecords(ctx, records)
+	}
+
+	r.write_mu.Lock()
+	defer r.write_mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err != nil {
+		return abort(err)
+	}
+
+	for _, op := range r.journal {
+
+		// Replayed writes may fail for reasons that only apply to the current generation (for example
+		// removing a feature that is not in the new generation) so errors are ignored
+
+		op(ctx, next)
+	}
+
+	previous := r.current.Swap(next)
+	r.journal = nil
+
+	previous.retire(ctx)
+	return nil
+}
+
+// newGenerationCache returns a new, empty, `featureCache` instance for a generation created by `Rebuild`.
+func (r *RTreeSpatialDatabase) newGenerationCache(ctx context.Context) (featureCache, error) {
+
+	q := url.Values{}
+
+	for k, v := range r.cache_params {
+		q[k] = v
+	}
+
+	// The cache_root directory is still in use by the current generation so disk caches for new
+	// generations are stored in a new directory inside it which is removed when they are replaced
+
+	if q.Get("cache") == cacheDisk {
+		return newTempDiskCache(ctx, q.Get("cache_root"))
+	}
+
+	return newFeatureCache(ctx, q)
+}
@@ -0,0 +1,285 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestSpatialDatabaseRebuild(t *testing.T) {
+
+	ctx := context.Background()
+
+	for _, database_uri := range []string{"rtree://", fmt.Sprintf("rtree://?cache=disk&cache_root=%s", t.TempDir())} {
+
+		db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		rtree_db := db.(*RTreeSpatialDatabase)
+
+		err = IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/dateline")
+
+		if err != nil {
+			t.Fatalf("Failed to index spatial database, %v", err)
+		}
+
+		// Feature 1 is not in the sources used to rebuild the database so it should be removed
+
+		b := orb.Bound{Min: orb.Point{50, 50}, Max: orb.Point{51, 51}}
+		err = db.IndexFeature(ctx, newTestFeature(t, 1, b.ToPolygon()))
+
+		if err != nil {
+			t.Fatalf("Failed to index feature, %v", err)
+		}
+
+		stop := make(chan bool)
+		wg := new(sync.WaitGroup)
+
+		// Queries for a feature in both the old and new generations should always succeed
+
+		for i := 0; i < 4; i++ {
+
+			wg.Add(1)
+
+			go func() {
+
+				defer wg.Done()
+
+				c := orb.Point{0.5, 0.5}
+
+				for {
+
+					select {
+					case <-stop:
+						return
+					default:
+						// pass
+					}
+
+					rsp, err := db.PointInPolygon(ctx, &c)
+
+					if err != nil {
+						t.Errorf("Failed to perform point in polygon query, %v", err)
+						return
+					}
+
+					assertIds(t, rsp.Results(), []string{"9000003"})
+				}
+			}()
+		}
+
+		// Features written while the rebuild is in progress should be replayed on the new generation
+
+		last_written := int64(0)
+
+		wg.Add(1)
+
+		go func() {
+
+			defer wg.Done()
+
+			for i := int64(0); ; i++ {
+
+				select {
+				case <-stop:
+					return
+				default:
+					// pass
+				}
+
+				id := 2000 + i%10
+
+				x := float64(60 + id%10)
+				b := orb.Bound{Min: orb.Point{x, 60}, Max: orb.Point{x + 1, 61}}
+
+				err := db.IndexFeature(ctx, newTestFeature(t, id, b.ToPolygon()))
+
+				if err != nil {
+					t.Errorf("Failed to index feature %d, %v", id, err)
+					return
+				}
+
+				last_written = id
+			}
+		}()
+
+		err = rtree_db.Rebuild(ctx, `directory://?_exclude=\.go$`, "fixtures/dateline", "fixtures/microhoods")
+
+		close(stop)
+		wg.Wait()
+
+		if err != nil {
+			t.Fatalf("Failed to rebuild database for %s, %v", database_uri, err)
+		}
+
+		g := rtree_db.current.Load()
+
+		_, ok := g.lookup["1"]
+
+		if ok {
+			t.Fatalf("Expected feature 1 to be removed by rebuild")
+		}
+
+		if last_written == 0 {
+			t.Fatalf("Expected features to be written during rebuild")
+		}
+
+		_, ok = g.lookup[fmt.Sprintf("%d", last_written)]
+
+		if !ok {
+			t.Fatalf("Expected feature %d, written during rebuild, to be in the new generation", last_written)
+		}
+
+		c := orb.Point{-71.120168, 42.376015}
+
+		rsp, err := db.PointInPolygon(ctx, &c)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertIds(t, rsp.Results(), []string{"1108712253"})
+	}
+}
+
+func TestSpatialDatabaseRebuildFailure(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	err = IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/dateline")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	err = rtree_db.Rebuild(ctx, "directory://", "fixtures/does-not-exist")
+
+	if err == nil {
+		t.Fatalf("Expected rebuild with missing source to fail")
+	}
+
+	if rtree_db.journal != nil {
+		t.Fatalf("Expected journal to be reset after failed rebuild")
+	}
+
+	c := orb.Point{0.5, 0.5}
+
+	rsp, err := db.PointInPolygon(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	assertIds(t, rsp.Results(), []string{"9000003"})
+}
+
+func TestSpatialDatabaseRebuildDiskCache(t *testing.T) {
+
+	ctx := context.Background()
+
+	cache_root := t.TempDir()
+
+	db, err := NewRTreeSpatialDatabase(ctx, fmt.Sprintf("rtree://?cache=disk&cache_root=%s", cache_root))
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	err = IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/dateline")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	// countEntries returns the number of files and directories in cache_root
+
+	countEntries := func() (int, int) {
+
+		entries, err := os.ReadDir(cache_root)
+
+		if err != nil {
+			t.Fatalf("Failed to read %s, %v", cache_root, err)
+		}
+
+		files := 0
+		dirs := 0
+
+		for _, e := range entries {
+
+			if e.IsDir() {
+				dirs += 1
+			} else {
+				files += 1
+			}
+		}
+
+		return files, dirs
+	}
+
+	files, dirs := countEntries()
+
+	if files == 0 || dirs != 0 {
+		t.Fatalf("Expected cache items in %s before rebuild, got %d files and %d directories", cache_root, files, dirs)
+	}
+
+	// Each rebuild should store its cache in a single directory inside cache_root and remove the files, or
+	// directory, of the generation it replaces
+
+	for i := 0; i < 2; i++ {
+
+		err = rtree_db.Rebuild(ctx, "directory://", "fixtures/dateline")
+
+		if err != nil {
+			t.Fatalf("Failed to rebuild database, %v", err)
+		}
+
+		files, dirs := countEntries()
+
+		if files != 0 || dirs != 1 {
+			t.Fatalf("Expected a single cache directory in %s after rebuild, got %d files and %d directories", cache_root, files, dirs)
+		}
+	}
+
+	c := orb.Point{0.5, 0.5}
+
+	rsp, err := db.PointInPolygon(ctx, &c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	assertIds(t, rsp.Results(), []string{"9000003"})
+
+	err = db.Close(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to close database, %v", err)
+	}
+
+	files, dirs = countEntries()
+
+	if files != 0 || dirs != 0 {
+		t.Fatalf("Expected %s to be empty after close, got %d files and %d directories", cache_root, files, dirs)
+	}
+}
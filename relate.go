@@ -0,0 +1,150 @@
+package rtree
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/paulmach/orb"
+	"github.com/whosonfirst/go-whosonfirst-spatial"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// Relation defines the spatial relationship between a query geometry and the features in the database.
+type Relation string
+
+// RelationIntersects matches features which share any points with the query geometry.
+const RelationIntersects Relation = "intersects"
+
+// RelationContains matches features which are entirely contained by the query geometry.
+const RelationContains Relation = "contains"
+
+// RelationWithin matches features which entirely contain the query geometry.
+const RelationWithin Relation = "within"
+
+// Relate returns the SPR records for all the features whose relationship to 'orb_geom' (which must be a
+// Polygon or MultiPolygon) matches 'relation'. Candidates are derived from the rtree and then tested against
+// their actual geometries.
+func (r *RTreeSpatialDatabase) Relate(ctx context.Context, orb_geom orb.Geometry, relation Relation, filters ...spatial.Filter) (spr.StandardPlacesResults, error) {
+
+	query_func := func(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool) {
+		r.RelateWithChannels(ctx, rsp_ch, err_ch, done_ch, orb_geom, relation, filters...)
+	}
+
+	return r.collectResults(ctx, query_func)
+}
+
+// RelateWithChannels dispatches the SPR records for all the features whose relationship to 'orb_geom' matches
+// 'relation' to 'rsp_ch'. Errors are dispatched to 'err_ch' and 'done_ch' is notified when the query is complete.
+func (r *RTreeSpatialDatabase) RelateWithChannels(ctx context.Context, rsp_ch chan spr.StandardPlacesResult, err_ch chan error, done_ch chan bool, orb_geom orb.Geometry, relation Relation, filters ...spatial.Filter) {
+
+	defer sendDone(ctx, done_ch)
+
+	query_polygons := polygons(orb_geom)
+
+	if len(query_polygons) == 0 {
+		sendError(ctx, err_ch, fmt.Errorf("Unsupported geometry type '%s'", orb_geom.GeoJSONType()))
+		return
+	}
+
+	test_func, err := relationTestFunc(query_polygons, relation)
+
+	if err != nil {
+		sendError(ctx, err_ch, err)
+		return
+	}
+
+	rect, err := newRectFromBound(orb_geom.Bound())
+
+	if err != nil {
+		sendError(ctx, err_ch, fmt.Errorf("Failed to derive rtree bounds, %w", err))
+		return
+	}
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	rows, err := r.getIntersectsByRect(g, &rect)
+
+	if err != nil {
+		sendError(ctx, err_ch, err)
+		return
+	}
+
+	// A feature intersects the query geometry if any one of its parts does but the other relations
+	// need to consider all of a feature's parts
+
+	test_parts := relation == RelationIntersects
+
+	r.inflateResultsWithChannels(ctx, g, rsp_ch, err_ch, rows, test_func, test_parts, filters...)
+}
+
+// relationTestFunc returns a `geometryTestFunc` for testing candidate geometries against 'query_polygons'
+// using 'relation'.
+func relationTestFunc(query_polygons []orb.Polygon, relation Relation) (geometryTestFunc, error) {
+
+	var test_func geometryTestFunc
+
+	switch relation {
+	case RelationIntersects:
+
+		test_func = func(candidate_geom orb.Geometry) bool {
+
+			for _, candidate_poly := range polygons(candidate_geom) {
+
+				for _, query_poly := range query_polygons {
+
+					if polygonsIntersect(query_poly, candidate_poly) {
+						return true
+					}
+				}
+			}
+
+			return false
+		}
+
+	case RelationContains:
+
+		test_func = func(candidate_geom orb.Geometry) bool {
+
+			candidate_polygons := polygons(candidate_geom)
+
+			if len(candidate_polygons) == 0 {
+				return false
+			}
+
+			for _, candidate_poly := range candidate_polygons {
+
+				if !multiPolygonContainsPolygon(query_polygons, candidate_poly) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+	case RelationWithin:
+
+		test_func = func(candidate_geom orb.Geometry) bool {
+
+			candidate_polygons := polygons(candidate_geom)
+
+			if len(candidate_polygons) == 0 {
+				return false
+			}
+
+			for _, query_poly := range query_polygons {
+
+				if !multiPolygonContainsPolygon(candidate_polygons, query_poly) {
+					return false
+				}
+			}
+
+			return true
+		}
+
+	default:
+		return nil, fmt.Errorf("Unsupported relation '%s'", relation)
+	}
+
+	return test_func, nil
+}
@@ -0,0 +1,82 @@
+package rtree
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+)
+
+func TestSpatialDatabaseRelate(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	features := map[int64]orb.Bound{
+		1: orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{10, 10}},
+		2: orb.Bound{Min: orb.Point{2, 2}, Max: orb.Point{4, 4}},
+		3: orb.Bound{Min: orb.Point{20, 20}, Max: orb.Point{22, 22}},
+	}
+
+	for id, b := range features {
+
+		err := db.IndexFeature(ctx, newTestFeature(t, id, b.ToPolygon()))
+
+		if err != nil {
+			t.Fatalf("Failed to index feature %d, %v", id, err)
+		}
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+
+	query_poly := orb.Bound{Min: orb.Point{1, 1}, Max: orb.Point{5, 5}}.ToPolygon()
+	other_poly := orb.Bound{Min: orb.Point{19, 19}, Max: orb.Point{23, 23}}.ToPolygon()
+
+	query_multi := orb.MultiPolygon{query_poly, other_poly}
+
+	tests := []struct {
+		Geometry orb.Geometry
+		Relation Relation
+		Expected []string
+	}{
+		{query_poly, RelationIntersects, []string{"1", "2"}},
+		{query_poly, RelationContains, []string{"2"}},
+		{query_poly, RelationWithin, []string{"1"}},
+		{query_multi, RelationIntersects, []string{"1", "2", "3"}},
+		{query_multi, RelationContains, []string{"2", "3"}},
+		{query_multi, RelationWithin, []string{}},
+	}
+
+	for _, test := range tests {
+
+		rsp, err := rtree_db.Relate(ctx, test.Geometry, test.Relation)
+
+		if err != nil {
+			t.Fatalf("Failed to perform %s query, %v", test.Relation, err)
+		}
+
+		ids := make([]string, 0)
+
+		for _, s := range rsp.Results() {
+			ids = append(ids, s.Id())
+		}
+
+		slices.Sort(ids)
+
+		if !slices.Equal(ids, test.Expected) {
+			t.Fatalf("Expected %v for %s %s query but got %v", test.Expected, test.Geometry.GeoJSONType(), test.Relation, ids)
+		}
+	}
+
+	_, err = rtree_db.Relate(ctx, query_poly, Relation("touches"))
+
+	if err == nil {
+		t.Fatalf("Expected unsupported relation to fail")
+	}
+}
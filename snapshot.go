@@ -0,0 +1,394 @@
+package rtree
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+// Snapshots are gzip-compressed streams of newline-delimited JSON. The first line is a `snapshotHeader`
+// followed by `snapshotHeader.Records` cache records and then `snapshotHeader.Entries` rtree index entries.
+
+const snapshotVersion int = 1
+
+const snapshotSPRTypeDefault string = "wof"
+
+const snapshotSPRTypeAlt string = "alt"
+
+type snapshotHeader struct {
+	Version int   `json:"version"`
+	Created int64 `json:"created"`
+	Records int   `json:"records"`
+	Entries int   `json:"entries"`
+}
+
+type snapshotRecord struct {
+	Key      string            `json:"key"`
+	Geometry *geojson.Geometry `json:"geometry"`
+	SPRType  string            `json:"spr_type"`
+	SPR      json.RawMessage   `json:"spr"`
+	// Body and BodyCompressed are only present if the database was created with `store_raw=true`.
+	Body           []byte `json:"body,omitempty"`
+	BodyCompressed bool   `json:"body_compressed,omitempty"`
+	// EncodedGeometry and GeometryEncoding are only present if the database was created with a `geometry_encoding` parameter.
+	EncodedGeometry  []byte `json:"encoded_geometry,omitempty"`
+	GeometryEncoding string `json:"geometry_encoding,omitempty"`
+}
+
+type snapshotEntry struct {
+	Id        string    `json:"id"`
+	FeatureId string    `json:"feature_id"`
+	IsAlt     bool      `json:"is_alt"`
+	AltLabel  string    `json:"alt_label"`
+	Part      int       `json:"part"`
+	Bounds    orb.Bound `json:"bounds"`
+}
+
+// WriteSnapshotFile writes a snapshot of the database to 'path'. The snapshot is written to a temporary
+// file first and then moved in to place so that readers never see a partial snapshot.
+func (r *RTreeSpatialDatabase) WriteSnapshotFile(ctx context.Context, path string) error {
+
+	abs_path, err := filepath.Abs(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to derive absolute path for %s, %w", path, err)
+	}
+
+	wr, err := os.CreateTemp(filepath.Dir(abs_path), filepath.Base(abs_path))
+
+	if err != nil {
+		return fmt.Errorf("Failed to create temporary file for %s, %w", abs_path, err)
+	}
+
+	tmp_path := wr.Name()
+	defer os.Remove(tmp_path)
+
+	err = r.WriteSnapshot(ctx, wr)
+
+	if err != nil {
+		wr.Close()
+		return err
+	}
+
+	err = wr.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close %s, %w", tmp_path, err)
+	}
+
+	// os.CreateTemp creates files which are only readable by their owner
+
+	err = os.Chmod(tmp_path, 0644)
+
+	if err != nil {
+		return fmt.Errorf("Failed to set permissions for %s, %w", tmp_path, err)
+	}
+
+	err = os.Rename(tmp_path, abs_path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to move %s to %s, %w", tmp_path, abs_path, err)
+	}
+
+	return nil
+}
+
+// WriteSnapshot writes the rtree index entries and cached geometries and SPR records for the database
+// to 'wr' in a format that can be read by `ReadSnapshot`.
+func (r *RTreeSpatialDatabase) WriteSnapshot(ctx context.Context, wr io.Writer) error {
+
+	// Prevent any writes, rather than taking a read lock, so the lookup table and cache are stable while the
+	// snapshot is written but queries are not blocked by writes waiting for the snapshot to finish
+
+	r.write_mu.Lock()
+	defer r.write_mu.Unlock()
+
+	g := r.acquireGeneration()
+	defer g.release(ctx)
+
+	count_records, err := g.cache.Count(ctx)
+
+	if err != nil {
+		return fmt.Errorf("Failed to count cache items, %w", err)
+	}
+
+	count_entries := 0
+
+	for _, entries := range g.lookup {
+
+		count_entries += len(entries)
+	}
+
+	gz := gzip.NewWriter(wr)
+	enc := json.NewEncoder(gz)
+
+	header := &snapshotHeader{
+		Version: snapshotVersion,
+		Created: time.Now().Unix(),
+		Records: count_records,
+		Entries: count_entries,
+	}
+
+	err = enc.Encode(header)
+
+	if err != nil {
+		return fmt.Errorf("Failed to encode snapshot header, %w", err)
+	}
+
+	count_written := 0
+
+	err = g.cache.Iterate(ctx, func(key string, cache_item *RTreeCache) error {
+
+		rec, err := newSnapshotRecord(key, cache_item)
+
+		if err != nil {
+			return fmt.Errorf("Failed to create snapshot record for %s, %w", key, err)
+		}
+
+		err = enc.Encode(rec)
+
+		if err != nil {
+			return fmt.Errorf("Failed to encode snapshot record for %s, %w", key, err)
+		}
+
+		count_written += 1
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	if count_written != count_records {
+		return fmt.Errorf("Expected to write %d snapshot records but wrote %d", count_records, count_written)
+	}
+
+	for _, entries := range g.lookup {
+
+		for _, sp := range entries {
+
+			e := &snapshotEntry{
+				Id:        sp.Id,
+				FeatureId: sp.FeatureId,
+				IsAlt:     sp.IsAlt,
+				AltLabel:  sp.AltLabel,
+				Part:      sp.Part,
+				Bounds:    boundFromRect(*sp.Rect),
+			}
+
+			err = enc.Encode(e)
+
+			if err != nil {
+				return fmt.Errorf("Failed to encode snapshot entry for %s, %w", sp.Id, err)
+			}
+		}
+	}
+
+	err = gz.Close()
+
+	if err != nil {
+		return fmt.Errorf("Failed to close snapshot, %w", err)
+	}
+
+	return nil
+}
+
+// ReadSnapshotFile reads the snapshot in 'path' (produced by `WriteSnapshotFile`) in to the database.
+func (r *RTreeSpatialDatabase) ReadSnapshotFile(ctx context.Context, path string) error {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return fmt.Errorf("Failed to open %s, %w", path, err)
+	}
+
+	defer fh.Close()
+
+	return r.ReadSnapshot(ctx, fh)
+}
+
+// ReadSnapshot reads a snapshot (produced by `WriteSnapshot`) from 'fh' and adds its index entries and cached
+// records to the database. Any existing entries and cached records for the features in the snapshot are replaced.
+func (r *RTreeSpatialDatabase) ReadSnapshot(ctx context.Context, fh io.Reader) error {
+
+	gz, err := gzip.NewReader(fh)
+
+	if err != nil {
+		return fmt.Errorf("Failed to create gzip reader, %w", err)
+	}
+
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+
+	var header *snapshotHeader
+
+	err = dec.Decode(&header)
+
+	if err != nil {
+		return fmt.Errorf("Failed to decode snapshot header, %w", err)
+	}
+
+	if header.Version != snapshotVersion {
+		return fmt.Errorf("Unsupported snapshot version %d", header.Version)
+	}
+
+	// The snapshot is decoded in to a list of index records, one for each cache item, which are then loaded in
+	// to the database in bulk. Loading them replaces any existing entries for the same features (and alt labels)
+	// so reading a snapshot in to a non-empty database, or reading the same snapshot twice, does not duplicate them.
+
+	records := make([]*indexRecord, header.Records)
+	lookup := make(map[string]*indexRecord)
+
+	for i := 0; i < header.Records; i++ {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		var rec *snapshotRecord
+
+		err := dec.Decode(&rec)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decode snapshot record at offset %d, %w", i, err)
+		}
+
+		cache_item, err := rec.cacheItem()
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive cache item for %s, %w", rec.Key, err)
+		}
+
+		feature_id, alt_label, ok := strings.Cut(rec.Key, ":")
+
+		if !ok {
+			return fmt.Errorf("Invalid snapshot record key '%s'", rec.Key)
+		}
+
+		records[i] = &indexRecord{
+			CacheKey:  rec.Key,
+			CacheItem: cache_item,
+			FeatureId: feature_id,
+			AltLabel:  alt_label,
+			Entries:   make([]*RTreeSpatialIndex, 0),
+		}
+
+		lookup[rec.Key] = records[i]
+	}
+
+	for i := 0; i < header.Entries; i++ {
+
+		var e *snapshotEntry
+
+		err := dec.Decode(&e)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decode snapshot entry at offset %d, %w", i, err)
+		}
+
+		rect, err := newRectFromBound(e.Bounds)
+
+		if err != nil {
+			return fmt.Errorf("Failed to derive rtree bounds for %s, %w", e.Id, err)
+		}
+
+		key := cacheKey(e.FeatureId, e.AltLabel)
+
+		rec, ok := lookup[key]
+
+		if !ok {
+			return fmt.Errorf("Snapshot entry %s does not have a snapshot record", e.Id)
+		}
+
+		sp := &RTreeSpatialIndex{
+			Rect:      &rect,
+			Id:        e.Id,
+			FeatureId: e.FeatureId,
+			IsAlt:     e.IsAlt,
+			AltLabel:  e.AltLabel,
+			Part:      e.Part,
+		}
+
+		rec.Entries = append(rec.Entries, sp)
+	}
+
+	return r.loadIndexRecords(ctx, records)
+}
+
+func newSnapshotRecord(key string, cache_item *RTreeCache) (*snapshotRecord, error) {
+
+	spr_type := snapshotSPRTypeDefault
+
+	switch cache_item.SPR.(type) {
+	case *spr.WOFStandardPlacesResult:
+		// pass
+	case *spr.WOFAltStandardPlacesResult:
+		spr_type = snapshotSPRTypeAlt
+	default:
+		return nil, fmt.Errorf("Unsupported SPR type %T", cache_item.SPR)
+	}
+
+	enc_spr, err := json.Marshal(cache_item.SPR)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to marshal SPR, %w", err)
+	}
+
+	rec := &snapshotRecord{
+		Key:              key,
+		Geometry:         cache_item.Geometry,
+		SPRType:          spr_type,
+		SPR:              enc_spr,
+		Body:             cache_item.Body,
+		BodyCompressed:   cache_item.BodyCompressed,
+		EncodedGeometry:  cache_item.EncodedGeometry,
+		GeometryEncoding: cache_item.GeometryEncoding,
+	}
+
+	return rec, nil
+}
+
+func (rec *snapshotRecord) cacheItem() (*RTreeCache, error) {
+
+	var s spr.StandardPlacesResult
+
+	switch rec.SPRType {
+	case snapshotSPRTypeDefault:
+		s = new(spr.WOFStandardPlacesResult)
+	case snapshotSPRTypeAlt:
+		s = new(spr.WOFAltStandardPlacesResult)
+	default:
+		return nil, fmt.Errorf("Unsupported SPR type '%s'", rec.SPRType)
+	}
+
+	err := json.Unmarshal(rec.SPR, s)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to unmarshal SPR, %w", err)
+	}
+
+	cache_item := &RTreeCache{
+		Geometry:         rec.Geometry,
+		SPR:              s,
+		Body:             rec.Body,
+		BodyCompressed:   rec.BodyCompressed,
+		EncodedGeometry:  rec.EncodedGeometry,
+		GeometryEncoding: rec.GeometryEncoding,
+	}
+
+	return cache_item, nil
+}
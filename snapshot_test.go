@@ -0,0 +1,268 @@
+package rtree
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/whosonfirst/go-whosonfirst-spatial/database"
+	"github.com/whosonfirst/go-whosonfirst-spatial/filter"
+	"github.com/whosonfirst/go-whosonfirst-spatial/geo"
+)
+
+func TestSpatialDatabaseSnapshot(t *testing.T) {
+
+	ctx := context.Background()
+
+	tests := map[int64]Criteria{
+		1108712253: Criteria{Longitude: -71.120168, Latitude: 42.376015, IsCurrent: 1},   // Old Cambridge
+		420561633:  Criteria{Longitude: -122.395268, Latitude: 37.794893, IsCurrent: 0},  // Superbowl City
+		420780729:  Criteria{Longitude: -122.421529, Latitude: 37.743168, IsCurrent: -1}, // Liminal Zone of Deliciousness
+	}
+
+	db, err := database.NewSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	err = database.IndexDatabaseWithIterator(ctx, db, "directory://", "fixtures/microhoods")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	snapshot_path := filepath.Join(t.TempDir(), "microhoods.snapshot")
+
+	err = db.(*RTreeSpatialDatabase).WriteSnapshotFile(ctx, snapshot_path)
+
+	if err != nil {
+		t.Fatalf("Failed to write snapshot, %v", err)
+	}
+
+	database_uri := fmt.Sprintf("rtree://?snapshot=%s", snapshot_path)
+
+	snapshot_db, err := database.NewSpatialDatabase(ctx, database_uri)
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database from snapshot, %v", err)
+	}
+
+	for expected, criteria := range tests {
+
+		c, err := geo.NewCoordinate(criteria.Longitude, criteria.Latitude)
+
+		if err != nil {
+			t.Fatalf("Failed to create new coordinate, %v", err)
+		}
+
+		i, err := filter.NewSPRInputs()
+
+		if err != nil {
+			t.Fatalf("Failed to create SPR inputs, %v", err)
+		}
+
+		i.IsCurrent = []int64{criteria.IsCurrent}
+
+		f, err := filter.NewSPRFilterFromInputs(i)
+
+		if err != nil {
+			t.Fatalf("Failed to create SPR filter from inputs, %v", err)
+		}
+
+		spr, err := snapshot_db.PointInPolygon(ctx, c, f)
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		results := spr.Results()
+		count := len(results)
+
+		if count != 1 {
+			t.Fatalf("Expected 1 result but got %d for '%d'", count, expected)
+		}
+
+		first := results[0]
+
+		if first.Id() != strconv.FormatInt(expected, 10) {
+			t.Fatalf("Expected %d but got %s", expected, first.Id())
+		}
+
+		if first.Name() == "" {
+			t.Fatalf("Expected name for %d to be preserved in snapshot", expected)
+		}
+	}
+}
+
+func TestSpatialDatabaseSnapshotReload(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	err = IndexDatabaseWithIterator(ctx, db, `directory://?_exclude=\.go$`, "fixtures/microhoods")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	rtree_db := db.(*RTreeSpatialDatabase)
+	expected_size := rtree_db.current.Load().rtree.Size()
+
+	snapshot_path := filepath.Join(t.TempDir(), "microhoods.snapshot")
+
+	err = rtree_db.WriteSnapshotFile(ctx, snapshot_path)
+
+	if err != nil {
+		t.Fatalf("Failed to write snapshot, %v", err)
+	}
+
+	info, err := os.Stat(snapshot_path)
+
+	if err != nil {
+		t.Fatalf("Failed to stat snapshot, %v", err)
+	}
+
+	if info.Mode().Perm() != 0644 {
+		t.Fatalf("Expected snapshot to have mode 0644 but got %v", info.Mode().Perm())
+	}
+
+	// Reading the snapshot in to the database it was written from, twice, should not duplicate any entries
+
+	for i := 0; i < 2; i++ {
+
+		err = rtree_db.ReadSnapshotFile(ctx, snapshot_path)
+
+		if err != nil {
+			t.Fatalf("Failed to read snapshot, %v", err)
+		}
+	}
+
+	g := rtree_db.current.Load()
+
+	if g.rtree.Size() != expected_size {
+		t.Fatalf("Expected %d rtree entries but got %d", expected_size, g.rtree.Size())
+	}
+
+	count_entries := 0
+
+	for _, entries := range g.lookup {
+		count_entries += len(entries)
+	}
+
+	if count_entries != expected_size {
+		t.Fatalf("Expected %d lookup entries but got %d", expected_size, count_entries)
+	}
+
+	c, err := geo.NewCoordinate(-122.421529, 37.743168)
+
+	if err != nil {
+		t.Fatalf("Failed to create new coordinate, %v", err)
+	}
+
+	rsp, err := db.PointInPolygon(ctx, c)
+
+	if err != nil {
+		t.Fatalf("Failed to perform point in polygon query, %v", err)
+	}
+
+	if len(rsp.Results()) != 1 {
+		t.Fatalf("Expected 1 result but got %d", len(rsp.Results()))
+	}
+}
+
+func TestSpatialDatabaseSnapshotTruncated(t *testing.T) {
+
+	ctx := context.Background()
+
+	db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer db.Close(ctx)
+
+	err = IndexDatabaseWithIterator(ctx, db, `directory://?_exclude=\.go$`, "fixtures/microhoods")
+
+	if err != nil {
+		t.Fatalf("Failed to index spatial database, %v", err)
+	}
+
+	var snapshot bytes.Buffer
+
+	err = db.(*RTreeSpatialDatabase).WriteSnapshot(ctx, &snapshot)
+
+	if err != nil {
+		t.Fatalf("Failed to write snapshot, %v", err)
+	}
+
+	gz, err := gzip.NewReader(&snapshot)
+
+	if err != nil {
+		t.Fatalf("Failed to create gzip reader, %v", err)
+	}
+
+	body, err := io.ReadAll(gz)
+
+	if err != nil {
+		t.Fatalf("Failed to read snapshot, %v", err)
+	}
+
+	// Drop the last entry so that the snapshot fails to decode after all of its records and most of its
+	// entries have been read
+
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	lines = lines[0 : len(lines)-1]
+
+	var truncated bytes.Buffer
+
+	wr := gzip.NewWriter(&truncated)
+	wr.Write(bytes.Join(lines, []byte("\n")))
+	wr.Close()
+
+	other_db, err := NewRTreeSpatialDatabase(ctx, "rtree://")
+
+	if err != nil {
+		t.Fatalf("Failed to create new spatial database, %v", err)
+	}
+
+	defer other_db.Close(ctx)
+
+	rtree_db := other_db.(*RTreeSpatialDatabase)
+
+	err = rtree_db.ReadSnapshot(ctx, &truncated)
+
+	if err == nil {
+		t.Fatalf("Expected truncated snapshot to fail")
+	}
+
+	g := rtree_db.current.Load()
+
+	if g.rtree.Size() != 0 {
+		t.Fatalf("Expected no rtree entries but got %d", g.rtree.Size())
+	}
+
+	count, err := g.cache.Count(ctx)
+
+	if err != nil {
+		t.Fatalf("Failed to count cache items, %v", err)
+	}
+
+	if count != 0 {
+		t.Fatalf("Expected no cache items but got %d", count)
+	}
+}
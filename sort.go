@@ -0,0 +1,263 @@
+package rtree
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2/sort"
+)
+
+// Query results are gathered from a pool of goroutines so the order in which they are emitted is not stable.
+// Before they are returned results are sorted using the list of whosonfirst/go-whosonfirst-spr/v2/sort URIs in the
+// `sort_uri` parameter, in order, with each subsequent sorter applied to the results which the one before it
+// considers equal. In addition to the sorters provided by that package (`placetype://`, `name://` and
+// `inception://`) this package registers:
+//
+// * `area://` – Smallest bounding box area first.
+// * `id://` – By numeric ID, in ascending order, and then by path (which distinguishes alternate geometries for
+//   the same feature).
+//
+// An `id://` sorter is always applied last so the results of a query are always in the same order. If no sort URIs
+// are specified results are sorted using `placetype://`.
+
+// The sort URI used if no sort URIs are specified.
+const defaultSortURI string = "placetype://"
+
+func init() {
+
+	ctx := context.Background()
+
+	sort.RegisterSorter(ctx, "area", newAreaSorter)
+	sort.RegisterSorter(ctx, "id", newIdSorter)
+}
+
+// newResultSorters returns the list of `sort.Sorter` instances for 'sort_uris' or, if 'sort_uris' is empty, for
+// the default sort URI.
+func newResultSorters(ctx context.Context, sort_uris []string) ([]sort.Sorter, error) {
+
+	if len(sort_uris) == 0 {
+		sort_uris = []string{defaultSortURI}
+	}
+
+	sorters := make([]sort.Sorter, len(sort_uris))
+
+	for i, sort_uri := range sort_uris {
+
+		s, err := sort.NewSorter(ctx, sort_uri)
+
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create sorter for '%s', %w", sort_uri, err)
+		}
+
+		sorters[i] = s
+	}
+
+	return sorters, nil
+}
+
+// sortResults returns 'results' sorted using 'sorters' and then by ID and path.
+func sortResults(ctx context.Context, results []spr.StandardPlacesResult, sorters []sort.Sorter) ([]spr.StandardPlacesResult, error) {
+
+	if len(results) == 0 {
+		return results, nil
+	}
+
+	// The placetype sorter only defines a partial order (placetypes which are not ancestors or descendants of
+	// each other are considered equal) and, like the other sorters, uses an unstable sort. Putting the results
+	// in a canonical order first means that sorters always see the same input, for the same set of results, and
+	// that results with the same placetype are adjacent which is what the follow-on sorters expect.
+
+	slices.SortFunc(results, compareCanonical)
+
+	follow_on := append(slices.Clone(sorters[1:]), &idSorter{})
+
+	sorted, err := sorters[0].Sort(ctx, sort.NewSortedStandardPlacesResults(results), follow_on...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to sort results, %w", err)
+	}
+
+	// Follow-on sorters group results by key and will drop or duplicate results whose keys are not adjacent, or
+	// are empty, so check that nothing has gone astray rather than returning the wrong results
+
+	if len(sorted.Results()) != len(results) {
+		return nil, fmt.Errorf("Failed to sort results, expected %d results but got %d", len(results), len(sorted.Results()))
+	}
+
+	return sorted.Results(), nil
+}
+
+// areaSorter implements the `sort.Sorter` interface, sorting results by the area of their bounding box.
+type areaSorter struct {
+	sort.Sorter
+}
+
+func newAreaSorter(ctx context.Context, uri string) (sort.Sorter, error) {
+	s := &areaSorter{}
+	return s, nil
+}
+
+func (s *areaSorter) Sort(ctx context.Context, results spr.StandardPlacesResults, follow_on_sorters ...sort.Sorter) (spr.StandardPlacesResults, error) {
+
+	to_sort := results.Results()
+
+	slices.SortStableFunc(to_sort, func(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+		return cmp.Compare(boundingBoxArea(a), boundingBoxArea(b))
+	})
+
+	key_func := func(ctx context.Context, s spr.StandardPlacesResult) (string, error) {
+		return strconv.FormatFloat(boundingBoxArea(s), 'g', -1, 64), nil
+	}
+
+	return applyFollowOnSorters(ctx, to_sort, key_func, follow_on_sorters...)
+}
+
+// idSorter implements the `sort.Sorter` interface, sorting results by ID and then by path.
+type idSorter struct {
+	sort.Sorter
+}
+
+func newIdSorter(ctx context.Context, uri string) (sort.Sorter, error) {
+	s := &idSorter{}
+	return s, nil
+}
+
+func (s *idSorter) Sort(ctx context.Context, results spr.StandardPlacesResults, follow_on_sorters ...sort.Sorter) (spr.StandardPlacesResults, error) {
+
+	to_sort := results.Results()
+
+	slices.SortStableFunc(to_sort, func(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+
+		v := compareIds(a, b)
+
+		if v != 0 {
+			return v
+		}
+
+		return cmp.Compare(a.Path(), b.Path())
+	})
+
+	key_func := func(ctx context.Context, s spr.StandardPlacesResult) (string, error) {
+		return fmt.Sprintf("%s#%s", s.Id(), s.Path()), nil
+	}
+
+	return applyFollowOnSorters(ctx, to_sort, key_func, follow_on_sorters...)
+}
+
+// applyFollowOnSorters applies 'follow_on_sorters', if there are any, to each group of results in 'sorted' which
+// have the same key.
+func applyFollowOnSorters(ctx context.Context, sorted []spr.StandardPlacesResult, key_func sort.ApplyFollowOnSortersKeyFunc, follow_on_sorters ...sort.Sorter) (spr.StandardPlacesResults, error) {
+
+	if len(follow_on_sorters) == 0 {
+		return sort.NewSortedStandardPlacesResults(sorted), nil
+	}
+
+	final, err := sort.ApplyFollowOnSorters(ctx, sorted, key_func, follow_on_sorters...)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to apply follow on sorters, %w", err)
+	}
+
+	return sort.NewSortedStandardPlacesResults(final), nil
+}
+
+// compareCanonical compares 'a' and 'b' by placetype (ancestors first, then by name), ID and path.
+func compareCanonical(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+
+	v := comparePlacetypes(a, b)
+
+	if v != 0 {
+		return v
+	}
+
+	v = cmp.Compare(a.Placetype(), b.Placetype())
+
+	if v != 0 {
+		return v
+	}
+
+	v = compareIds(a, b)
+
+	if v != 0 {
+		return v
+	}
+
+	return cmp.Compare(a.Path(), b.Path())
+}
+
+// comparePlacetypes compares 'a' and 'b' by the rank of their placetypes, ancestors first, which is consistent
+// with the order used by the `placetype://` sorter. Records with an unknown placetype are sorted last.
+func comparePlacetypes(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+
+	a_rank := placetypeRank(a.Placetype())
+	b_rank := placetypeRank(b.Placetype())
+
+	switch {
+	case a_rank == b_rank:
+		return 0
+	case a_rank == -1:
+		return 1
+	case b_rank == -1:
+		return -1
+	default:
+		return cmp.Compare(a_rank, b_rank)
+	}
+}
+
+// compareIds compares the IDs of 'a' and 'b' numerically or, if either ID is not a number, lexically.
+func compareIds(a spr.StandardPlacesResult, b spr.StandardPlacesResult) int {
+
+	a_id, a_err := strconv.ParseInt(a.Id(), 10, 64)
+	b_id, b_err := strconv.ParseInt(b.Id(), 10, 64)
+
+	if a_err != nil || b_err != nil {
+		return cmp.Compare(a.Id(), b.Id())
+	}
+
+	return cmp.Compare(a_id, b_id)
+}
+
+// boundingBoxArea returns the area, in square degrees, of the bounding box for 'r'. Bounding boxes whose minimum
+// longitude is greater than their maximum longitude are assumed to cross the antimeridian.
+func boundingBoxArea(r spr.StandardPlacesResult) float64 {
+
+	width := r.MaxLongitude() - r.MinLongitude()
+
+	if width < 0.0 {
+		width += 360.0
+	}
+
+	return width * (r.MaxLatitude() - r.MinLatitude())
+}
+
+// placetypeRanks caches the rank of each placetype since deriving it means walking the placetype hierarchy.
+var placetypeRanks = new(sync.Map)
+
+// placetypeRank returns the number of ancestors, for all roles, of the placetype 'name' in the placetype hierarchy
+// or -1 if 'name' is not a valid placetype. A placetype always has a higher rank than its ancestors since it has
+// all of their ancestors and the ancestors themselves.
+func placetypeRank(name string) int {
+
+	v, ok := placetypeRanks.Load(name)
+
+	if ok {
+		return v.(int)
+	}
+
+	rank := -1
+
+	pt, err := placetypes.GetPlacetypeByName(name)
+
+	if err == nil {
+		rank = len(placetypes.AncestorsForRoles(pt, placetypes.AllRoles()))
+	}
+
+	placetypeRanks.Store(name, rank)
+	return rank
+}
@@ -0,0 +1,229 @@
+package rtree
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/whosonfirst/go-whosonfirst-placetypes"
+	"github.com/whosonfirst/go-whosonfirst-spr/v2"
+)
+
+func TestSpatialDatabaseSortResults(t *testing.T) {
+
+	ctx := context.Background()
+
+	// Nested squares containing 1,1 whose placetypes, names and areas are in different orders
+
+	features := map[int64]struct {
+		placetype string
+		name      string
+		size      float64
+	}{
+		100: {"region", "Zed", 10},
+		101: {"locality", "Alpha", 2},
+		102: {"neighbourhood", "Alpha", 4},
+		99:  {"neighbourhood", "Alpha", 3},
+	}
+
+	bodies := make([][]byte, 0)
+
+	for id, details := range features {
+		poly := orb.Bound{Min: orb.Point{0, 0}, Max: orb.Point{details.size, details.size}}.ToPolygon()
+		bodies = append(bodies, newTestFeatureWithPlacetype(t, id, details.placetype, details.name, poly))
+	}
+
+	tests := map[string][]string{
+		"rtree://":                       {"100", "101", "99", "102"},
+		"rtree://?sort_uri=placetype://": {"100", "101", "99", "102"},
+		"rtree://?sort_uri=placetype://&sort_uri=area://":   {"100", "101", "99", "102"},
+		"rtree://?sort_uri=placetype://&sort_uri=name://":   {"100", "101", "99", "102"},
+		"rtree://?sort_uri=area://":                         {"101", "99", "102", "100"},
+		"rtree://?sort_uri=id://":                           {"99", "100", "101", "102"},
+		"rtree://?sort_uri=name://":                         {"99", "101", "102", "100"},
+		"rtree://?sort_uri=name://&sort_uri=area://":        {"101", "99", "102", "100"},
+		"rtree://?sort_uri=name://&sort_uri=placetype://":   {"101", "99", "102", "100"},
+		"rtree://?sort_uri=inception://":                    {"99", "100", "101", "102"},
+		"rtree://?sort_uri=area://&query_workers=1000":      {"101", "99", "102", "100"},
+		"rtree://?sort_uri=placetype://&query_workers=1000": {"100", "101", "99", "102"},
+	}
+
+	for database_uri, expected := range tests {
+
+		db, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+		if err != nil {
+			t.Fatalf("Failed to create new spatial database for %s, %v", database_uri, err)
+		}
+
+		defer db.Close(ctx)
+
+		err = db.(*RTreeSpatialDatabase).IndexFeatures(ctx, bodies...)
+
+		if err != nil {
+			t.Fatalf("Failed to index features, %v", err)
+		}
+
+		pip_rsp, err := db.PointInPolygon(ctx, &orb.Point{1, 1})
+
+		if err != nil {
+			t.Fatalf("Failed to perform point in polygon query, %v", err)
+		}
+
+		assertOrderedIds(t, pip_rsp.Results(), expected)
+
+		intersects_rsp, err := db.(*RTreeSpatialDatabase).Intersects(ctx, orb.Bound{Min: orb.Point{0.5, 0.5}, Max: orb.Point{1, 1}})
+
+		if err != nil {
+			t.Fatalf("Failed to perform intersects query, %v", err)
+		}
+
+		assertOrderedIds(t, intersects_rsp.Results(), expected)
+	}
+
+	for _, database_uri := range []string{"rtree://?sort_uri=bogus://", "rtree://?sort_uri=area://&sort_uri=%25"} {
+
+		_, err := NewRTreeSpatialDatabase(ctx, database_uri)
+
+		if err == nil {
+			t.Fatalf("Expected %s to fail", database_uri)
+		}
+	}
+}
+
+func TestPlacetypeRank(t *testing.T) {
+
+	order := []string{"microhood", "neighbourhood", "locality", "county", "region", "country", "continent", "planet"}
+
+	for i := 1; i < len(order); i++ {
+
+		if placetypeRank(order[i-1]) <= placetypeRank(order[i]) {
+			t.Fatalf("Expected %s to rank higher than %s", order[i-1], order[i])
+		}
+	}
+
+	if placetypeRank("bogus") != -1 {
+		t.Fatalf("Expected invalid placetype to have rank -1")
+	}
+
+	// Ranks must be consistent with the order used by the placetype:// sorter, which sorts ancestors first
+
+	all, err := placetypes.PlacetypesForRoles(placetypes.AllRoles())
+
+	if err != nil {
+		t.Fatalf("Failed to derive placetypes, %v", err)
+	}
+
+	for _, a := range all {
+
+		for _, b := range all {
+
+			if placetypes.IsDescendant(a, b) && placetypeRank(a.Name) >= placetypeRank(b.Name) {
+				t.Fatalf("Expected %s, an ancestor of %s, to have a lower rank", a.Name, b.Name)
+			}
+		}
+	}
+}
+
+func TestSortResultsCanonical(t *testing.T) {
+
+	ctx := context.Background()
+
+	sorters, err := newResultSorters(ctx, nil)
+
+	if err != nil {
+		t.Fatalf("Failed to create sorters, %v", err)
+	}
+
+	// Placetypes which are not ancestors or descendants of each other, or are unknown, are considered equal by
+	// the placetype:// sorter so the results should only be in the same order for every permutation of the
+	// input if it has been put in a canonical order first
+
+	expected := []string{"1", "3", "2", "4", "6", "5"}
+
+	results := []spr.StandardPlacesResult{
+		newTestSPR("1", "country"),
+		newTestSPR("2", "localadmin"),
+		newTestSPR("3", "county"),
+		newTestSPR("4", "neighbourhood"),
+		newTestSPR("5", "bogus"),
+		newTestSPR("6", "neighbourhood"),
+	}
+
+	for i := 0; i < len(results); i++ {
+
+		input := append(slices.Clone(results[i:]), results[:i]...)
+		slices.Reverse(input)
+
+		sorted, err := sortResults(ctx, input, sorters)
+
+		if err != nil {
+			t.Fatalf("Failed to sort results, %v", err)
+		}
+
+		assertOrderedIds(t, sorted, expected)
+	}
+}
+
+// testSPR is a minimal `spr.StandardPlacesResult` implementation for testing sorters. Only the methods used by
+// the sorters are implemented.
+type testSPR struct {
+	spr.StandardPlacesResult
+	id        string
+	placetype string
+}
+
+func newTestSPR(id string, placetype string) spr.StandardPlacesResult {
+	return &testSPR{id: id, placetype: placetype}
+}
+
+func (s *testSPR) Id() string {
+	return s.id
+}
+
+func (s *testSPR) Placetype() string {
+	return s.placetype
+}
+
+func (s *testSPR) Name() string {
+	return ""
+}
+
+func (s *testSPR) Path() string {
+	return ""
+}
+
+func newTestFeatureWithPlacetype(t testing.TB, id int64, placetype string, name string, orb_geom orb.Geometry) []byte {
+
+	f, err := geojson.UnmarshalFeature(newTestFeature(t, id, orb_geom))
+
+	if err != nil {
+		t.Fatalf("Failed to unmarshal feature, %v", err)
+	}
+
+	f.Properties["wof:placetype"] = placetype
+	f.Properties["wof:name"] = name
+
+	body, err := f.MarshalJSON()
+
+	if err != nil {
+		t.Fatalf("Failed to marshal feature, %v", err)
+	}
+
+	return body
+}
+
+func assertOrderedIds(t *testing.T, results []spr.StandardPlacesResult, expected []string) {
+
+	ids := make([]string, 0)
+
+	for _, s := range results {
+		ids = append(ids, s.Id())
+	}
+
+	if !slices.Equal(ids, expected) {
+		t.Fatalf("Expected %v but got %v", expected, ids)
+	}
+}